@@ -0,0 +1,178 @@
+package sqlite
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/go-ap/errors"
+)
+
+// Backup writes a consistent copy of the database to destPath, while it's in use.
+// It uses "VACUUM INTO" which runs in a read transaction, so it doesn't block the writers,
+// and the resulting file contains all the committed data, including the one still in the WAL file.
+// The destination file must not exist.
+func (r *repo) Backup(ctx context.Context, destPath string) error {
+	if r == nil || r.ro == nil {
+		return errNotOpen
+	}
+	if destPath == "" {
+		return os.ErrNotExist
+	}
+	if _, err := os.Stat(destPath); err == nil {
+		return errors.Newf("backup destination %s already exists", destPath)
+	}
+	if _, err := r.ro.ExecContext(ctx, "VACUUM INTO ?;", destPath); err != nil {
+		_ = os.Remove(destPath)
+		return wrapSQLError(err, "unable to backup database to %s", destPath)
+	}
+	return nil
+}
+
+// Restore replaces the database found at conf.Path with the backup from srcPath.
+// The backup is validated before replacing the current database: it needs to pass the SQLite integrity check,
+// and its schema version can't be newer than the one supported by the package. A backup with an older
+// schema version needs to be migrated afterwards.
+//
+// The database must not be opened by any other process while it gets restored.
+func Restore(conf Config, srcPath string) error {
+	if conf.Path == "" {
+		return os.ErrNotExist
+	}
+	p, err := getFullPath(conf)
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stat(srcPath); err != nil {
+		return err
+	}
+
+	// NOTE(marius): we work on a copy in the same directory as the database, so we don't change the backup file
+	// and the final rename is atomic.
+	tmp := p + ".restore"
+	if err = copyFile(srcPath, tmp); err != nil {
+		return err
+	}
+	if err = validateBackup(conf.Driver, tmp); err != nil {
+		_ = removeDBFiles(tmp, true)
+		return err
+	}
+	_ = removeDBFiles(tmp, false)
+
+	// NOTE(marius): the current database is moved aside together with its WAL and shared memory files,
+	// which must not be applied to the restored one, and it's put back if the backup can't take its place.
+	old := p + ".old"
+	if err = removeDBFiles(old, true); err != nil {
+		_ = removeDBFiles(tmp, true)
+		return err
+	}
+	if err = moveDBFiles(p, old); err != nil {
+		_ = removeDBFiles(tmp, true)
+		return err
+	}
+	if err = os.Rename(tmp, p); err != nil {
+		_ = removeDBFiles(tmp, true)
+		if mErr := moveDBFiles(old, p); mErr != nil {
+			return errors.Annotatef(mErr, "unable to put back database %s, it can be found at %s", p, old)
+		}
+		return errors.Annotatef(err, "unable to replace database %s", p)
+	}
+	_ = removeDBFiles(old, true)
+	return nil
+}
+
+// validateBackup opens the backup at p read-only, so the checks don't change it, not even its journal mode.
+func validateBackup(driver, p string) error {
+	conn, err := sqlOpen(driver, p, ConnectionOptions{readOnly: true})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	var result string
+	if err = conn.QueryRowContext(ctx, "PRAGMA integrity_check;").Scan(&result); err != nil {
+		return wrapSQLError(err, "unable to check backup integrity")
+	}
+	if result != "ok" {
+		return errors.Newf("backup failed integrity check: %s", result)
+	}
+
+	version, err := loadSchemaVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if version > schemaVersion() {
+		return errors.Newf("backup schema version %d is newer than the supported version %d", version, schemaVersion())
+	}
+	if version == 0 {
+		var count int
+		if err = conn.QueryRowContext(ctx, "SELECT count(*) FROM sqlite_schema;").Scan(&count); err != nil {
+			return wrapSQLError(err, "unable to load backup schema")
+		}
+		if count == 0 {
+			return errors.Newf("backup does not contain any tables")
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return errors.Annotatef(err, "unable to copy %s to %s", src, dst)
+	}
+	if err = out.Sync(); err != nil {
+		_ = out.Close()
+		return errors.Annotatef(err, "unable to copy %s to %s", src, dst)
+	}
+	return out.Close()
+}
+
+// dbFiles returns the paths of the database at p, and of its WAL and shared memory files.
+func dbFiles(p string) []string {
+	return []string{p, p + "-wal", p + "-shm"}
+}
+
+// moveDBFiles renames the database at from, and its WAL and shared memory files, to to.
+// When one of them can't be renamed, the ones already moved are put back.
+func moveDBFiles(from, to string) error {
+	src, dst := dbFiles(from), dbFiles(to)
+	for i := range src {
+		err := os.Rename(src[i], dst[i])
+		if err == nil || os.IsNotExist(err) {
+			continue
+		}
+		for j := i - 1; j >= 0; j-- {
+			_ = os.Rename(dst[j], src[j])
+		}
+		return errors.Annotatef(err, "unable to move %s", filepath.Base(src[i]))
+	}
+	return nil
+}
+
+// removeDBFiles removes the WAL and shared memory files of the database at p, and when withDB is set,
+// the database file itself.
+func removeDBFiles(p string, withDB bool) error {
+	files := []string{p + "-wal", p + "-shm"}
+	if withDB {
+		files = append(files, p)
+	}
+	for _, f := range files {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return errors.Annotatef(err, "unable to remove %s", filepath.Base(f))
+		}
+	}
+	return nil
+}
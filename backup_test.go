@@ -0,0 +1,196 @@
+package sqlite
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_repo_Backup(t *testing.T) {
+	ob := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType}
+	existing := filepath.Join(t.TempDir(), "existing.sqlite")
+	be.NilErr(t, os.WriteFile(existing, []byte("test"), 0o600))
+
+	tests := []struct {
+		name     string
+		setupFns []initFn
+		dest     string
+		wantErr  error
+	}{
+		{
+			name:    "not open",
+			dest:    filepath.Join(t.TempDir(), "backup.sqlite"),
+			wantErr: errNotOpen,
+		},
+		{
+			name:     "empty destination",
+			setupFns: []initFn{withOpenRoot, withBootstrap},
+			wantErr:  os.ErrNotExist,
+		},
+		{
+			name:     "existing destination",
+			setupFns: []initFn{withOpenRoot, withBootstrap},
+			dest:     existing,
+			wantErr:  errors.Newf("backup destination %s already exists", existing),
+		},
+		{
+			name:     "with items",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{ob})},
+			dest:     filepath.Join(t.TempDir(), "backup.sqlite"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mockRepo(t, fields{path: t.TempDir()}, tt.setupFns...)
+			t.Cleanup(r.Close)
+
+			err := r.Backup(context.Background(), tt.dest)
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Errorf("Backup() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+				return
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			b := &repo{path: tt.dest, logFn: t.Logf, errFn: t.Errorf}
+			be.NilErr(t, b.Open())
+			t.Cleanup(b.Close)
+
+			version, err := loadSchemaVersion(context.Background(), b.conn)
+			be.NilErr(t, err)
+			be.Equal(t, schemaVersion(), version)
+
+			it, err := b.Load(ob.ID)
+			be.NilErr(t, err)
+			be.Equal(t, ob.ID, it.GetLink())
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	ob := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType}
+
+	backupDir := t.TempDir()
+	backup := filepath.Join(backupDir, "backup.sqlite")
+	src := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{ob}))
+	be.NilErr(t, src.Backup(context.Background(), backup))
+	src.Close()
+
+	invalid := filepath.Join(backupDir, "invalid.sqlite")
+	be.NilErr(t, os.WriteFile(invalid, []byte("not a database"), 0o600))
+
+	n := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap, withSchemaVersion(schemaVersion()+1))
+	newer := n.path
+	n.Close()
+
+	tests := []struct {
+		name    string
+		src     string
+		wantErr bool
+	}{
+		{
+			name:    "missing backup",
+			src:     filepath.Join(backupDir, "missing.sqlite"),
+			wantErr: true,
+		},
+		{
+			name:    "invalid backup",
+			src:     invalid,
+			wantErr: true,
+		},
+		{
+			name:    "newer schema version",
+			src:     newer,
+			wantErr: true,
+		},
+		{
+			name: "valid backup",
+			src:  backup,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := Config{Path: t.TempDir(), LogFn: t.Logf, ErrFn: t.Errorf}
+			be.NilErr(t, Bootstrap(conf))
+
+			err := Restore(conf, tt.src)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Restore() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			r, err := New(conf)
+			be.NilErr(t, err)
+			be.NilErr(t, r.Open())
+			t.Cleanup(r.Close)
+
+			_, err = r.Load(ob.ID)
+			if tt.wantErr && !errors.IsNotFound(err) {
+				t.Errorf("Load() after failed Restore() expected not found error, received %v", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Load() after Restore() error = %v", err)
+			}
+		})
+	}
+}
+
+func Test_moveDBFiles(t *testing.T) {
+	dir := t.TempDir()
+	from := filepath.Join(dir, "storage.sqlite")
+	to := filepath.Join(dir, "storage.sqlite.old")
+	for _, f := range []string{from, from + "-wal"} {
+		be.NilErr(t, os.WriteFile(f, []byte(filepath.Base(f)), 0o600))
+	}
+
+	// NOTE(marius): a non empty directory in the place of the WAL file makes its rename fail
+	be.NilErr(t, os.MkdirAll(filepath.Join(to+"-wal", "busy"), 0o700))
+	if err := moveDBFiles(from, to); err == nil {
+		t.Fatalf("moveDBFiles() expected error, received nil")
+	}
+	for _, f := range []string{from, from + "-wal"} {
+		data, err := os.ReadFile(f)
+		be.NilErr(t, err)
+		be.Equal(t, filepath.Base(f), string(data))
+	}
+	if _, err := os.Stat(to); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be moved back, received %v", to, err)
+	}
+
+	be.NilErr(t, os.RemoveAll(to+"-wal"))
+	be.NilErr(t, moveDBFiles(from, to))
+	for _, f := range []string{to, to + "-wal"} {
+		_, err := os.Stat(f)
+		be.NilErr(t, err)
+	}
+	if _, err := os.Stat(from); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be moved, received %v", from, err)
+	}
+}
+
+func Test_validateBackup_keepsJournalMode(t *testing.T) {
+	backup := filepath.Join(t.TempDir(), "backup.sqlite")
+	src := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap)
+	be.NilErr(t, src.Backup(context.Background(), backup))
+	src.Close()
+
+	// NOTE(marius): the 18th and 19th bytes of the database header hold the journal mode, they're 2 for WAL
+	journalMode := func() []byte {
+		header := make([]byte, 100)
+		f, err := os.Open(backup)
+		be.NilErr(t, err)
+		defer f.Close()
+		_, err = f.ReadAt(header, 0)
+		be.NilErr(t, err)
+		return header[18:20]
+	}
+	before := journalMode()
+	be.NilErr(t, validateBackup("", backup))
+	be.AllEqual(t, before, journalMode())
+}
@@ -0,0 +1,187 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// batchSize is the number of rows inserted by a single statement in SaveMany.
+const batchSize = 100
+
+// batchItem is an item to be saved by SaveMany, together with its position in the received list.
+type batchItem struct {
+	pos int
+	it  vocab.Item
+	raw []byte
+}
+
+// SaveMany saves all the items in a single transaction.
+// The items are grouped by the table they get stored in, and are written using multi-row insert statements.
+//
+// The returned slice has the error of each of the items, at the same position as the item, or nil if it has been
+// saved successfully. The error return value is set only when the transaction itself fails, in which case
+// none of the items have been saved.
+func (r *repo) SaveMany(ctx context.Context, items ...vocab.Item) ([]error, error) {
+	if r == nil || r.conn == nil {
+		return nil, errNotOpen
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	var errs []error
+	err := r.writeTx(ctx, func(tx *sql.Tx) error {
+		errs = make([]error, len(items))
+		return r.saveMany(ctx, tx, errs, items...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if r.cache != nil {
+		for i, it := range items {
+			if errs[i] == nil {
+				r.cache.Store(it.GetLink(), it)
+			}
+		}
+	}
+	return errs, nil
+}
+
+func (r *repo) saveMany(ctx context.Context, tx *sql.Tx, errs []error, items ...vocab.Item) error {
+	tables := make(map[string][]batchItem)
+	// NOTE(marius): we keep the order in which we first encountered the tables, so the writes are deterministic
+	order := make([]string, 0, 4)
+	for i, it := range items {
+		if vocab.IsNil(it) {
+			errs[i] = errNilItem
+			continue
+		}
+		raw, err := encodeItemFn(it)
+		if err != nil {
+			errs[i] = errors.Annotatef(err, "unable to marshal item %s", it.GetLink())
+			continue
+		}
+		table := tableForItem(it)
+		if _, ok := tables[table]; !ok {
+			order = append(order, table)
+		}
+		tables[table] = append(tables[table], batchItem{pos: i, it: it, raw: raw})
+	}
+
+	for _, table := range order {
+		if err := r.insertBatch(ctx, tx, table, errs, tables[table]); err != nil {
+			return err
+		}
+	}
+
+	for i, it := range items {
+		if errs[i] == nil {
+			r.addToParentCollection(ctx, tx, it)
+		}
+	}
+	return nil
+}
+
+// insertBatch writes the items to table, batchSize rows at a time.
+// When a multi-row statement fails, its rows are inserted one by one, so the error can be attributed
+// to the item that caused it. Errors that make the whole transaction fail are returned.
+func (r *repo) insertBatch(ctx context.Context, tx *sql.Tx, table string, errs []error, items []batchItem) error {
+	// NOTE(marius): all the chunks, except the last one, have the same number of rows, so they share a statement
+	stmts := make(map[int]*sql.Stmt)
+	defer func() {
+		for _, st := range stmts {
+			_ = st.Close()
+		}
+	}()
+
+	for start := 0; start < len(items); start += batchSize {
+		chunk := items[start:min(start+batchSize, len(items))]
+
+		st, ok := stmts[len(chunk)]
+		if !ok {
+			var err error
+			if st, err = tx.PrepareContext(ctx, insertManyQuery(table, len(chunk))); err != nil {
+				return wrapSQLError(err, "unable to prepare statement")
+			}
+			stmts[len(chunk)] = st
+		}
+
+		params := make([]any, 0, 2*len(chunk))
+		for _, b := range chunk {
+			params = append(params, string(b.raw), b.it.GetLink())
+		}
+		// NOTE(marius): the revisions are written in the same savepoint as the rows that replace them,
+		// so they are rolled back together if the statement fails.
+		err := savepoint(ctx, tx, func() error {
+			for _, b := range chunk {
+				if err := r.saveRevision(ctx, tx, table, b.it.GetLink(), b.raw); err != nil {
+					return err
+				}
+			}
+			_, err := st.ExecContext(ctx, params...)
+			return err
+		})
+		if err == nil {
+			for _, b := range chunk {
+				if err = updateSearchIndex(ctx, tx, table, b.it.GetLink()); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if IsRetryable(err) {
+			return wrapSQLError(err, "unable to save items")
+		}
+		r.logFn("batch insert into %s failed, inserting the items one by one: %s", table, err)
+
+		query := insertManyQuery(table, 1)
+		for _, b := range chunk {
+			err = savepoint(ctx, tx, func() error {
+				if err := r.saveRevision(ctx, tx, table, b.it.GetLink(), b.raw); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, query, string(b.raw), b.it.GetLink())
+				return err
+			})
+			if err != nil {
+				if IsRetryable(err) {
+					return wrapSQLError(err, "unable to save items")
+				}
+				errs[b.pos] = wrapSQLError(err, "unable to save item %s", b.it.GetLink())
+				continue
+			}
+			if err = updateSearchIndex(ctx, tx, table, b.it.GetLink()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// savepoint runs fn inside a savepoint of tx, so the changes it made are rolled back when it fails,
+// while the ones made before by the transaction are kept.
+func savepoint(ctx context.Context, tx *sql.Tx, fn func() error) error {
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT batch;"); err != nil {
+		return wrapSQLError(err, "unable to create savepoint")
+	}
+	err := fn()
+	if err != nil {
+		if _, rErr := tx.ExecContext(ctx, "ROLLBACK TO batch;"); rErr != nil {
+			return wrapSQLError(rErr, "unable to roll back to savepoint")
+		}
+	}
+	if _, rErr := tx.ExecContext(ctx, "RELEASE batch;"); rErr != nil {
+		return wrapSQLError(rErr, "unable to release savepoint")
+	}
+	return err
+}
+
+func insertManyQuery(table string, rows int) string {
+	values := strings.TrimSuffix(strings.Repeat("(?, ?), ", rows), ", ")
+	return "INSERT OR REPLACE INTO " + table + " (raw, iri) VALUES " + values + ";"
+}
@@ -0,0 +1,160 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// malformedEncoder returns an encoder that generates invalid JSON for the item with iri.
+// NOTE(marius): invalid JSON makes the generated columns fail when the row gets inserted
+func malformedEncoder[F ~func(I) ([]byte, error), I any](enc F, iri vocab.IRI) F {
+	return func(it I) ([]byte, error) {
+		if i, ok := any(it).(vocab.Item); ok && i.GetLink() == iri {
+			return []byte(`{"id":`), nil
+		}
+		return enc(it)
+	}
+}
+
+func Test_repo_SaveMany(t *testing.T) {
+	many := make(vocab.ItemCollection, 0, 2*batchSize+10)
+	for i := range cap(many) {
+		many = append(many, &vocab.Object{ID: vocab.IRI(fmt.Sprintf("https://example.com/objects/%d", i)), Type: vocab.NoteType})
+	}
+	malformed := &vocab.Object{ID: "https://example.com/objects/malformed", Type: vocab.NoteType}
+
+	tests := []struct {
+		name     string
+		setupFns []initFn
+		items    vocab.ItemCollection
+		wantErrs []error
+		wantErr  error
+	}{
+		{
+			name:    "not open",
+			items:   vocab.ItemCollection{&vocab.Object{ID: "https://example.com/objects/1"}},
+			wantErr: errNotOpen,
+		},
+		{
+			name:     "empty",
+			setupFns: []initFn{withOpenRoot, withBootstrap},
+		},
+		{
+			name:     "mixed tables",
+			setupFns: []initFn{withOpenRoot, withBootstrap},
+			items: vocab.ItemCollection{
+				&vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType},
+				&vocab.Actor{ID: "https://example.com/actors/1", Type: vocab.PersonType},
+				&vocab.Activity{ID: "https://example.com/activities/1", Type: vocab.CreateType},
+				&vocab.OrderedCollection{ID: "https://example.com/actors/1/outbox", Type: vocab.OrderedCollectionType},
+				&vocab.Tombstone{ID: "https://example.com/actors/2", Type: vocab.TombstoneType},
+			},
+			wantErrs: make([]error, 5),
+		},
+		{
+			name:     "nil item",
+			setupFns: []initFn{withOpenRoot, withBootstrap},
+			items: vocab.ItemCollection{
+				&vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType},
+				nil,
+				&vocab.Object{ID: "https://example.com/objects/2", Type: vocab.NoteType},
+			},
+			wantErrs: []error{nil, errNilItem, nil},
+		},
+		{
+			name:     "more than a batch",
+			setupFns: []initFn{withOpenRoot, withBootstrap},
+			items:    many,
+			wantErrs: make([]error, len(many)),
+		},
+		{
+			name:     "failed row",
+			setupFns: []initFn{withOpenRoot, withBootstrap},
+			items: vocab.ItemCollection{
+				&vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType},
+				malformed,
+				&vocab.Object{ID: "https://example.com/objects/2", Type: vocab.NoteType},
+			},
+			wantErrs: []error{nil, errors.Newf("unable to save item %s", malformed.ID), nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mockRepo(t, fields{path: t.TempDir()}, tt.setupFns...)
+			t.Cleanup(r.Close)
+
+			encodeItemFn = malformedEncoder(vocab.MarshalJSON, malformed.ID)
+			t.Cleanup(func() { encodeItemFn = vocab.MarshalJSON })
+
+			errs, err := r.SaveMany(context.Background(), tt.items...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SaveMany() error = %v, expected %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			be.Equal(t, len(tt.wantErrs), len(errs))
+			for i, wantErr := range tt.wantErrs {
+				if wantErr == nil {
+					be.NilErr(t, errs[i])
+				} else if errs[i] == nil || !(errors.Is(errs[i], wantErr) || strings.HasPrefix(errs[i].Error(), wantErr.Error())) {
+					t.Errorf("SaveMany() item %d error = %v, expected %v", i, errs[i], wantErr)
+				}
+			}
+
+			for i, it := range tt.items {
+				if vocab.IsNil(it) {
+					continue
+				}
+				saved, err := r.Load(it.GetLink())
+				if errs[i] != nil {
+					if !errors.IsNotFound(err) {
+						t.Errorf("Load(%s) expected not found error for failed item, received %v", it.GetLink(), err)
+					}
+					continue
+				}
+				be.NilErr(t, err)
+				be.Equal(t, it.GetLink(), saved.GetLink())
+			}
+		})
+	}
+}
+
+func Test_repo_SaveMany_revisionsOfSavedItems(t *testing.T) {
+	ok := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType}
+	malformed := &vocab.Object{ID: "https://example.com/objects/malformed", Type: vocab.NoteType}
+
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap, withRevisions,
+		withGeneratedItems(vocab.ItemCollection{ok, malformed}))
+	t.Cleanup(r.Close)
+
+	encodeItemFn = malformedEncoder(vocab.MarshalJSON, malformed.ID)
+	t.Cleanup(func() { encodeItemFn = vocab.MarshalJSON })
+
+	updated := *ok
+	updated.Content = vocab.DefaultNaturalLanguage("updated")
+	changed := *malformed
+	changed.Content = vocab.DefaultNaturalLanguage("updated")
+
+	errs, err := r.SaveMany(context.Background(), &updated, &changed)
+	be.NilErr(t, err)
+	be.NilErr(t, errs[0])
+	if errs[1] == nil {
+		t.Fatalf("SaveMany() expected error for the malformed item")
+	}
+
+	revisions, err := r.Revisions(context.Background(), ok.ID)
+	be.NilErr(t, err)
+	be.Equal(t, 1, len(revisions))
+
+	// NOTE(marius): the item that failed to save must not have a revision, as it hasn't changed
+	revisions, err = r.Revisions(context.Background(), malformed.ID)
+	be.NilErr(t, err)
+	be.Equal(t, 0, len(revisions))
+}
@@ -1,11 +1,9 @@
 package sqlite
 
 import (
-	"fmt"
+	"context"
 	"os"
 	"strings"
-
-	"github.com/go-ap/errors"
 )
 
 func stringClean(qSql string) string {
@@ -20,7 +18,15 @@ func Clean(conf Config) error {
 	return os.RemoveAll(p)
 }
 
+// Bootstrap creates the database found at conf.Path, or brings an existing one up to the current schema version.
 func Bootstrap(conf Config) error {
+	conf.MigrateDryRun = false
+	return Migrate(conf)
+}
+
+// Migrate applies the pending schema migrations to the database found at conf.Path.
+// When conf.MigrateDryRun is set, the SQL of the pending migrations is only logged using conf.LogFn.
+func Migrate(conf Config) error {
 	if conf.Path == "" {
 		return os.ErrNotExist
 	}
@@ -30,9 +36,11 @@ func Bootstrap(conf Config) error {
 	}
 
 	r := repo{
-		path:  p,
-		logFn: defaultLogFn,
-		errFn: defaultLogFn,
+		path:     p,
+		logFn:    defaultLogFn,
+		errFn:    defaultLogFn,
+		connOpts: conf.Connection,
+		driver:   conf.Driver,
 	}
 	if conf.LogFn != nil {
 		r.logFn = conf.LogFn
@@ -45,43 +53,7 @@ func Bootstrap(conf Config) error {
 	}
 	defer r.Close()
 
-	exec := func(qRaw string, par ...interface{}) error {
-		qSql := fmt.Sprintf(qRaw, par...)
-		if _, err = r.conn.Exec(qSql); err != nil {
-			return errors.Annotatef(err, `unable to execute: "%s"`, stringClean(qSql))
-		}
-		return nil
-	}
-
-	if err = exec(createObjectsQuery); err != nil {
-		return err
-	}
-	if err = exec(createActivitiesQuery); err != nil {
-		return err
-	}
-	if err = exec(createActorsQuery); err != nil {
-		return err
-	}
-	if err = exec(createCollectionsQuery); err != nil {
-		return err
-	}
-	if err = exec(createMetaQuery); err != nil {
-		return err
-	}
-	if err = exec(createClientTable); err != nil {
-		return err
-	}
-	if err = exec(createAuthorizeTable); err != nil {
-		return err
-	}
-	if err = exec(createAccessTable); err != nil {
-		return err
-	}
-	if err = exec(createRefreshTable); err != nil {
-		return err
-	}
-
-	return nil
+	return r.migrate(context.Background(), conf.MigrateDryRun)
 }
 
 var tables = []string{
@@ -89,11 +61,16 @@ var tables = []string{
 	"actors",
 	"activities",
 	"collections",
+	"collection_items",
+	"search_docs",
+	"search",
 	"meta",
 	"clients",
 	"authorize",
 	"access",
 	"refresh",
+	"revisions",
+	"changes",
 }
 
 func (r *repo) Reset() {
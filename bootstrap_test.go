@@ -95,12 +95,9 @@ func TestBootstrap(t *testing.T) {
 			wantErr: &fs.PathError{Op: "stat", Path: filepath.Join(forbiddenPath, "should-fail"), Err: syscall.EACCES},
 		},
 		{
-			name: "forbidden",
-			arg:  Config{Path: forbiddenPath},
-			wantErr: errors.Annotatef(
-				errCantOpen,
-				`unable to execute: "CREATE TABLE IF NOT EXISTS objects (  "raw" TEXT,  "iri" TEXT NOT NULL constraint objects_key unique,  "id" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.id')) VIRTUAL ,  "type" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.type')) VIRTUAL,  "to" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.to')) VIRTUAL,  "bto" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.bto')) VIRTUAL,  "cc" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.cc')) VIRTUAL,  "bcc" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.bcc')) VIRTUAL,  "published" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.published')) VIRTUAL,  "updated" TEXT GENERATED ALWAYS AS (coalesce(json_extract(raw, '$.updated'), json_extract(raw, '$.deleted'), json_extract(raw, '$.published'))) VIRTUAL,  "url" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.url')) VIRTUAL,  "name" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.name')) VIRTUAL,  "summary" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.summary')) VIRTUAL,  "content" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.content')) VIRTUAL) STRICT;CREATE INDEX objects_type ON objects(type);CREATE INDEX objects_name ON objects(name);CREATE INDEX objects_content ON objects(content);CREATE INDEX objects_published ON objects(published);CREATE INDEX objects_updated ON objects(updated);"`,
-			),
+			name:    "forbidden",
+			arg:     Config{Path: forbiddenPath},
+			wantErr: errors.Annotatef(errCantOpen, "unable to load schema version"),
 		},
 	}
 	for _, tt := range tests {
@@ -216,3 +213,68 @@ func Test_repo_Reset(t *testing.T) {
 		})
 	}
 }
+
+const createLegacyCollectionsQuery = `
+CREATE TABLE IF NOT EXISTS collections (
+  "raw" TEXT,
+  "iri" TEXT NOT NULL constraint collections_key unique,
+  "id" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.id')) VIRTUAL,
+  "type" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.type')) VIRTUAL,
+  "to" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.to')) VIRTUAL,
+  "bto" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.bto')) VIRTUAL,
+  "cc" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.cc')) VIRTUAL,
+  "bcc" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.bcc')) VIRTUAL,
+  "published" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.published')) VIRTUAL,
+  "updated" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.updated')) VIRTUAL,
+  "items" TEXT DEFAULT '[]'
+) STRICT;
+CREATE INDEX collections_type ON collections(type);
+CREATE INDEX collections_published ON collections(published);
+CREATE INDEX collections_updated ON collections(updated);
+`
+
+func TestBootstrap_migrateCollectionItems(t *testing.T) {
+	base := t.TempDir()
+	colIRI := "https://example.com/inbox"
+	items := []string{"https://example.com/1", "https://example.com/2", "https://example.com/3"}
+
+	p, err := getFullPath(Config{Path: base})
+	be.NilErr(t, err)
+
+	r := repo{path: p, logFn: t.Logf, errFn: t.Errorf}
+	be.NilErr(t, r.Open())
+
+	_, err = r.conn.Exec(createLegacyCollectionsQuery)
+	be.NilErr(t, err)
+	_, err = r.conn.Exec(
+		"INSERT INTO collections (iri, raw, items) VALUES (?, ?, ?);",
+		colIRI, `{"id":"`+colIRI+`","type":"OrderedCollection"}`,
+		`["`+items[0]+`","`+items[1]+`","`+items[2]+`"]`,
+	)
+	be.NilErr(t, err)
+	r.Close()
+
+	be.NilErr(t, Bootstrap(Config{Path: base, LogFn: t.Logf, ErrFn: t.Errorf}))
+
+	be.NilErr(t, r.Open())
+	defer r.Close()
+
+	var count int
+	err = r.conn.QueryRow("SELECT count(*) FROM pragma_table_info('collections') WHERE name = 'items';").Scan(&count)
+	be.NilErr(t, err)
+	be.Equal(t, 0, count)
+
+	rows, err := r.conn.Query("SELECT item_iri, position FROM collection_items WHERE collection_iri = ? ORDER BY position;", colIRI)
+	be.NilErr(t, err)
+	defer rows.Close()
+
+	got := make([]string, 0)
+	for rows.Next() {
+		var iri string
+		var pos int
+		be.NilErr(t, rows.Scan(&iri, &pos))
+		be.Equal(t, len(got)+1, pos)
+		got = append(got, iri)
+	}
+	be.AllEqual(t, items, got)
+}
@@ -0,0 +1,140 @@
+package sqlite
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/cache"
+)
+
+// CacheMetrics holds the counters of a LRUCache.
+type CacheMetrics struct {
+	// Hits is the number of Load calls that found the item in the cache.
+	Hits uint64
+	// Misses is the number of Load calls that didn't find the item, or found it expired.
+	Misses uint64
+	// Evictions is the number of items removed from the cache because it was full, or because they expired.
+	Evictions uint64
+	// Entries is the number of items currently in the cache.
+	Entries int
+}
+
+// LRUCache is a cache.CanStore implementation that holds at most a fixed number of items, evicting
+// the least recently used ones when it is full. Optionally, the items expire after a TTL.
+type LRUCache struct {
+	maxEntries int
+	ttl        time.Duration
+
+	m     sync.Mutex
+	ll    *list.List
+	items map[vocab.IRI]*list.Element
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+type lruEntry struct {
+	iri     vocab.IRI
+	it      vocab.Item
+	expires time.Time
+}
+
+var _ cache.CanStore = new(LRUCache)
+
+// NewLRUCache returns a cache that holds at most maxEntries items, which expire after ttl.
+// A ttl of zero means that the items don't expire.
+func NewLRUCache(maxEntries int, ttl time.Duration) *LRUCache {
+	return &LRUCache{
+		maxEntries: max(maxEntries, 1),
+		ttl:        ttl,
+		ll:         list.New(),
+		items:      make(map[vocab.IRI]*list.Element),
+	}
+}
+
+// Load returns the item stored for iri, or nil if it isn't found in the cache.
+func (c *LRUCache) Load(iri vocab.IRI) vocab.Item {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	el, ok := c.items[iri]
+	if !ok {
+		c.misses.Add(1)
+		return nil
+	}
+	e := el.Value.(*lruEntry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		c.remove(el)
+		c.evictions.Add(1)
+		c.misses.Add(1)
+		return nil
+	}
+	c.ll.MoveToFront(el)
+	c.hits.Add(1)
+	return e.it
+}
+
+// Store saves it in the cache for iri, evicting the least recently used item if the cache is full.
+func (c *LRUCache) Store(iri vocab.IRI, it vocab.Item) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	var expires time.Time
+	if c.ttl > 0 {
+		expires = time.Now().Add(c.ttl)
+	}
+	if el, ok := c.items[iri]; ok {
+		e := el.Value.(*lruEntry)
+		e.it = it
+		e.expires = expires
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[iri] = c.ll.PushFront(&lruEntry{iri: iri, it: it, expires: expires})
+	for c.ll.Len() > c.maxEntries {
+		c.remove(c.ll.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// Delete removes the items stored for iris from the cache, together with the collections they belong to.
+func (c *LRUCache) Delete(iris ...vocab.IRI) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for _, iri := range iris {
+		if el, ok := c.items[iri]; ok {
+			c.remove(el)
+		}
+		// NOTE(marius): the collection that contains the item can be stale after the item changed
+		if col, _ := vocab.Split(iri); isCollectionIRI(col) {
+			if el, ok := c.items[col]; ok {
+				c.remove(el)
+			}
+		}
+	}
+	return true
+}
+
+// Metrics returns the current values of the cache counters.
+func (c *LRUCache) Metrics() CacheMetrics {
+	c.m.Lock()
+	entries := c.ll.Len()
+	c.m.Unlock()
+
+	return CacheMetrics{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   entries,
+	}
+}
+
+func (c *LRUCache) remove(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*lruEntry).iri)
+}
@@ -0,0 +1,142 @@
+package sqlite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestLRUCache(t *testing.T) {
+	ob1 := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType}
+	ob2 := &vocab.Object{ID: "https://example.com/objects/2", Type: vocab.NoteType}
+	ob3 := &vocab.Object{ID: "https://example.com/objects/3", Type: vocab.NoteType}
+
+	tests := []struct {
+		name        string
+		maxEntries  int
+		ttl         time.Duration
+		run         func(c *LRUCache)
+		wantLoaded  vocab.ItemCollection
+		wantMissing vocab.IRIs
+		want        CacheMetrics
+	}{
+		{
+			name:        "empty",
+			maxEntries:  2,
+			wantMissing: vocab.IRIs{ob1.ID},
+			want:        CacheMetrics{Misses: 1},
+		},
+		{
+			name:       "store and load",
+			maxEntries: 2,
+			run: func(c *LRUCache) {
+				c.Store(ob1.ID, ob1)
+			},
+			wantLoaded: vocab.ItemCollection{ob1},
+			want:       CacheMetrics{Hits: 1, Entries: 1},
+		},
+		{
+			name:       "evicts least recently used",
+			maxEntries: 2,
+			run: func(c *LRUCache) {
+				c.Store(ob1.ID, ob1)
+				c.Store(ob2.ID, ob2)
+				_ = c.Load(ob1.ID)
+				c.Store(ob3.ID, ob3)
+			},
+			wantLoaded:  vocab.ItemCollection{ob1, ob3},
+			wantMissing: vocab.IRIs{ob2.ID},
+			want:        CacheMetrics{Hits: 3, Misses: 1, Evictions: 1, Entries: 2},
+		},
+		{
+			name:       "store existing doesn't evict",
+			maxEntries: 2,
+			run: func(c *LRUCache) {
+				c.Store(ob1.ID, ob1)
+				c.Store(ob2.ID, ob2)
+				c.Store(ob1.ID, ob1)
+			},
+			wantLoaded: vocab.ItemCollection{ob1, ob2},
+			want:       CacheMetrics{Hits: 2, Entries: 2},
+		},
+		{
+			name:       "delete",
+			maxEntries: 2,
+			run: func(c *LRUCache) {
+				c.Store(ob1.ID, ob1)
+				c.Store(ob2.ID, ob2)
+				c.Delete(ob1.ID)
+			},
+			wantLoaded:  vocab.ItemCollection{ob2},
+			wantMissing: vocab.IRIs{ob1.ID},
+			want:        CacheMetrics{Hits: 1, Misses: 1, Entries: 1},
+		},
+		{
+			name:       "delete invalidates the parent collection",
+			maxEntries: 2,
+			run: func(c *LRUCache) {
+				c.Store("https://example.com/outbox", &vocab.OrderedCollection{ID: "https://example.com/outbox"})
+				c.Store("https://example.com/outbox/1", ob1)
+				c.Delete("https://example.com/outbox/1")
+			},
+			wantMissing: vocab.IRIs{"https://example.com/outbox", "https://example.com/outbox/1"},
+			want:        CacheMetrics{Misses: 2},
+		},
+		{
+			name:       "expired",
+			maxEntries: 2,
+			ttl:        time.Millisecond,
+			run: func(c *LRUCache) {
+				c.Store(ob1.ID, ob1)
+				time.Sleep(5 * time.Millisecond)
+			},
+			wantMissing: vocab.IRIs{ob1.ID},
+			want:        CacheMetrics{Misses: 1, Evictions: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewLRUCache(tt.maxEntries, tt.ttl)
+			if tt.run != nil {
+				tt.run(c)
+			}
+			for _, it := range tt.wantLoaded {
+				got := c.Load(it.GetLink())
+				if got == nil {
+					t.Errorf("Load(%s) expected item, received nil", it.GetLink())
+					continue
+				}
+				be.Equal(t, it.GetLink(), got.GetLink())
+			}
+			for _, iri := range tt.wantMissing {
+				if got := c.Load(iri); got != nil {
+					t.Errorf("Load(%s) expected nil, received %v", iri, got)
+				}
+			}
+			be.Equal(t, tt.want, c.Metrics())
+		})
+	}
+}
+
+func TestNew_withCache(t *testing.T) {
+	ob := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType}
+	c := NewLRUCache(10, 0)
+
+	conf := Config{Path: t.TempDir(), Cache: c, LogFn: t.Logf, ErrFn: t.Errorf}
+	be.NilErr(t, Bootstrap(conf))
+	r, err := New(conf)
+	be.NilErr(t, err)
+	be.NilErr(t, r.Open())
+	t.Cleanup(r.Close)
+
+	_, err = r.Save(ob)
+	be.NilErr(t, err)
+	_, err = r.Load(ob.ID)
+	be.NilErr(t, err)
+
+	m := c.Metrics()
+	be.True(t, m.Entries > 0)
+	be.True(t, m.Hits > 0)
+}
@@ -0,0 +1,238 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// DeleteActorOptions configures DeleteActor.
+type DeleteActorOptions struct {
+	// DryRun makes DeleteActor only report what would be removed, without changing the database.
+	DryRun bool
+	// Tombstone replaces the actor, and the objects and activities it owns with Tombstones,
+	// instead of removing them.
+	Tombstone bool
+}
+
+// DeleteActorReport lists what DeleteActor removed, or would remove in dry-run mode.
+type DeleteActorReport struct {
+	// Items are the IRIs of the actor, and of the objects, activities and collections it owns.
+	Items vocab.IRIs
+	// Rows is the number of rows removed, or updated for Tombstones, from each table.
+	Rows map[string]int64
+}
+
+func (d *DeleteActorReport) add(table string, n int64) {
+	if n > 0 {
+		d.Rows[table] += n
+	}
+}
+
+// rowsAffected returns the number of rows changed by the query that returned res and err.
+func rowsAffected(res sql.Result, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+var errDryRun = errors.Newf("dry run")
+
+// DeleteActor removes the actor at iri and everything it owns, in a single transaction:
+//   - the objects, activities, actors and collections with IRIs under the actor's IRI,
+//   - the objects attributed to the actor, and the activities the actor has performed,
+//   - the collection items of the removed collections, and the references to the removed items from other collections,
+//   - the metadata of the actor, the revisions of the removed items, and the OAuth tokens issued to the actor.
+//
+// NOTE(marius): only the attributedTo values that are a single IRI are matched.
+func (r *repo) DeleteActor(ctx context.Context, iri vocab.IRI, opts DeleteActorOptions) (DeleteActorReport, error) {
+	report := DeleteActorReport{Rows: make(map[string]int64)}
+	if r == nil || r.conn == nil {
+		return report, errNotOpen
+	}
+	if iri == "" {
+		return report, errors.NotFoundf("unable to delete actor with empty IRI")
+	}
+
+	var changed vocab.IRIs
+	err := r.writeTx(ctx, func(tx *sql.Tx) error {
+		report = DeleteActorReport{Rows: make(map[string]int64)}
+		changed = changed[:0]
+		if err := r.deleteActor(ctx, tx, iri, opts, &report, &changed); err != nil {
+			return err
+		}
+		if opts.DryRun {
+			// NOTE(marius): returning an error rolls back the transaction, so the report is exactly
+			// what would have been removed.
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return DeleteActorReport{}, err
+	}
+	if !opts.DryRun {
+		r.evict(report.Items...)
+		r.evict(changed...)
+	}
+	return report, nil
+}
+
+// ownedItemsQueries select the IRIs of the items owned by an actor from each table.
+// Their parameters are the actor's IRI, and the LIKE pattern for the IRIs under it.
+var ownedItemsQueries = map[string]string{
+	"actors":      "SELECT iri FROM actors WHERE iri = ? OR iri LIKE ? ESCAPE '\\';",
+	"objects":     "SELECT iri FROM objects WHERE json_extract(raw, '$.attributedTo') = ? OR iri LIKE ? ESCAPE '\\';",
+	"activities":  "SELECT iri FROM activities WHERE actor = ? OR iri LIKE ? ESCAPE '\\';",
+	"collections": "SELECT iri FROM collections WHERE iri = ? OR iri LIKE ? ESCAPE '\\';",
+}
+
+var itemTables = []string{"actors", "objects", "activities", "collections"}
+
+// deleteActor removes the actor at iri and everything it owns, the IRIs of the collections of other actors
+// that get changed are appended to changed.
+func (r *repo) deleteActor(ctx context.Context, tx *sql.Tx, iri vocab.IRI, opts DeleteActorOptions, report *DeleteActorReport, changed *vocab.IRIs) error {
+	pattern := likeEscape(iri.String()) + "/%"
+
+	owned := make(map[string]vocab.IRIs, len(itemTables))
+	for _, table := range itemTables {
+		iris, err := selectIRIs(ctx, tx, ownedItemsQueries[table], iri, pattern)
+		if err != nil {
+			return err
+		}
+		owned[table] = iris
+		report.Items = append(report.Items, iris...)
+	}
+	if len(owned["actors"]) == 0 {
+		return errors.NotFoundf("actor %s not found", iri)
+	}
+
+	// NOTE(marius): the collections are always removed, the other items can be replaced by Tombstones
+	for _, table := range itemTables {
+		for _, it := range owned[table] {
+			var n int64
+			var err error
+			if opts.Tombstone && table != "collections" {
+				n, err = tombstoneItem(ctx, tx, table, it)
+			} else {
+				n, err = rowsAffected(tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE iri = ?;", it))
+			}
+			if err != nil {
+				return wrapSQLError(err, "unable to delete %s", it)
+			}
+			report.add(table, n)
+			if err = removeFromSearchIndex(ctx, tx, it); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := r.deleteOwnedCollectionItems(ctx, tx, owned, opts, report, changed); err != nil {
+		return err
+	}
+
+	deletes := []struct {
+		table string
+		query string
+	}{
+		{table: "meta", query: "DELETE FROM meta WHERE iri = ?;"},
+		{table: "refresh", query: "DELETE FROM refresh WHERE access_token IN (SELECT token FROM access WHERE CAST(extra AS TEXT) = ?);"},
+		{table: "access", query: "DELETE FROM access WHERE CAST(extra AS TEXT) = ?;"},
+		{table: "authorize", query: "DELETE FROM authorize WHERE CAST(extra AS TEXT) = ?;"},
+	}
+	for _, d := range deletes {
+		n, err := rowsAffected(tx.ExecContext(ctx, d.query, iri))
+		if err != nil {
+			return wrapSQLError(err, "unable to delete from %s", d.table)
+		}
+		report.add(d.table, n)
+	}
+	for _, it := range report.Items {
+		n, err := rowsAffected(tx.ExecContext(ctx, "DELETE FROM revisions WHERE iri = ?;", it))
+		if err != nil {
+			return wrapSQLError(err, "unable to delete revisions of %s", it)
+		}
+		report.add("revisions", n)
+	}
+	return nil
+}
+
+// deleteOwnedCollectionItems removes the items of the removed collections, and, unless the items are replaced
+// by Tombstones, the removed items from the collections of other actors, updating their totalItems.
+func (r *repo) deleteOwnedCollectionItems(ctx context.Context, tx *sql.Tx, owned map[string]vocab.IRIs, opts DeleteActorOptions, report *DeleteActorReport, changed *vocab.IRIs) error {
+	for _, col := range owned["collections"] {
+		n, err := rowsAffected(tx.ExecContext(ctx, "DELETE FROM collection_items WHERE collection_iri = ?;", col))
+		if err != nil {
+			return wrapSQLError(err, "unable to delete items of %s", col)
+		}
+		report.add("collection_items", n)
+	}
+	if opts.Tombstone {
+		return nil
+	}
+
+	for _, table := range []string{"actors", "objects", "activities"} {
+		for _, it := range owned[table] {
+			n, cols, err := r.removeFromAllCollections(ctx, tx, it)
+			if err != nil {
+				return err
+			}
+			report.add("collection_items", n)
+			*changed = append(*changed, cols...)
+		}
+	}
+	return nil
+}
+
+// tombstoneItem replaces the item at iri in table with a Tombstone, and returns the number of rows updated.
+func tombstoneItem(ctx context.Context, tx *sql.Tx, table string, iri vocab.IRI) (int64, error) {
+	var typ sql.NullString
+	if err := tx.QueryRowContext(ctx, "SELECT type FROM "+table+" WHERE iri = ?;", iri).Scan(&typ); err != nil {
+		return 0, err
+	}
+	if typ.String == string(vocab.TombstoneType) {
+		return 0, nil
+	}
+	t := vocab.Tombstone{
+		ID:         iri,
+		Type:       vocab.TombstoneType,
+		FormerType: vocab.ActivityVocabularyType(typ.String),
+		Deleted:    time.Now().UTC(),
+	}
+	raw, err := encodeItemFn(&t)
+	if err != nil {
+		return 0, errors.Annotatef(err, "unable to marshal Tombstone")
+	}
+	return rowsAffected(tx.ExecContext(ctx, "UPDATE "+table+" SET raw = ? WHERE iri = ?;", string(raw), iri))
+}
+
+func selectIRIs(ctx context.Context, tx querier, query string, args ...any) (vocab.IRIs, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, wrapSQLError(err, "unable to run select")
+	}
+	defer rows.Close()
+
+	iris := make(vocab.IRIs, 0)
+	for rows.Next() {
+		var iri vocab.IRI
+		if err = rows.Scan(&iri); err != nil {
+			return nil, wrapSQLError(err, "scan values error")
+		}
+		iris = append(iris, iri)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, wrapSQLError(err, "unable to load IRIs")
+	}
+	return iris, nil
+}
+
+// likeEscape escapes the LIKE wildcards in s, using the '\' escape character.
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,142 @@
+package sqlite
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_repo_DeleteActor(t *testing.T) {
+	jdoe := &vocab.Actor{ID: "https://example.com/actors/jdoe", Type: vocab.PersonType}
+	outbox := &vocab.OrderedCollection{ID: "https://example.com/actors/jdoe/outbox", Type: vocab.OrderedCollectionType}
+	note := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType, AttributedTo: jdoe.ID}
+	create := &vocab.Activity{ID: "https://example.com/activities/1", Type: vocab.CreateType, Actor: jdoe.ID, Object: note.ID}
+
+	// NOTE(marius): the IRI of the second actor has the one of the first as prefix
+	jdoe2 := &vocab.Actor{ID: "https://example.com/actors/jdoe2", Type: vocab.PersonType}
+	outbox2 := &vocab.OrderedCollection{ID: "https://example.com/actors/jdoe2/outbox", Type: vocab.OrderedCollectionType}
+
+	withActors := func(t *testing.T, r *repo) *repo {
+		withGeneratedItems(vocab.ItemCollection{jdoe, outbox, note, create, jdoe2, outbox2})(t, r)
+		be.NilErr(t, r.AddTo(outbox.ID, create))
+		be.NilErr(t, r.AddTo(outbox2.ID, create))
+		be.NilErr(t, r.SaveMetadata(jdoe.ID, &Metadata{Pw: []byte("test")}))
+		return r
+	}
+	withTokens := func(t *testing.T, r *repo) *repo {
+		withClient(t, r)
+		auth := mockAuth("jdoe-code", defaultClient)
+		auth.UserData = jdoe.ID
+		be.NilErr(t, r.SaveAuthorize(auth))
+		access := mockAccess("jdoe-access", defaultClient)
+		access.AuthorizeData = auth
+		access.AccessData = nil
+		access.RefreshToken = "jdoe-refresh"
+		access.UserData = jdoe.ID
+		be.NilErr(t, r.SaveAccess(access))
+		return r
+	}
+
+	wantItems := vocab.IRIs{jdoe.ID, note.ID, create.ID, outbox.ID}
+	tests := []struct {
+		name        string
+		setupFns    []initFn
+		iri         vocab.IRI
+		opts        DeleteActorOptions
+		wantRows    map[string]int64
+		wantErr     error
+		wantRemoved bool
+		wantType    vocab.ActivityVocabularyType
+	}{
+		{
+			name:    "not open",
+			iri:     jdoe.ID,
+			wantErr: errNotOpen,
+		},
+		{
+			name:     "not found",
+			setupFns: []initFn{withOpenRoot, withBootstrap},
+			iri:      jdoe.ID,
+			wantErr:  errors.NotFoundf("actor %s not found", jdoe.ID),
+		},
+		{
+			name:     "dry run",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withActors},
+			iri:      jdoe.ID,
+			opts:     DeleteActorOptions{DryRun: true},
+			wantRows: map[string]int64{"actors": 1, "objects": 1, "activities": 1, "collections": 1, "collection_items": 2, "meta": 1},
+			wantType: vocab.PersonType,
+		},
+		{
+			name:        "remove",
+			setupFns:    []initFn{withOpenRoot, withBootstrap, withActors},
+			iri:         jdoe.ID,
+			wantRows:    map[string]int64{"actors": 1, "objects": 1, "activities": 1, "collections": 1, "collection_items": 2, "meta": 1},
+			wantRemoved: true,
+		},
+		{
+			name:        "remove, with OAuth2 tokens",
+			setupFns:    []initFn{withOpenRoot, withBootstrap, withActors, withTokens},
+			iri:         jdoe.ID,
+			wantRows:    map[string]int64{"actors": 1, "objects": 1, "activities": 1, "collections": 1, "collection_items": 2, "meta": 1, "access": 1, "refresh": 1, "authorize": 1},
+			wantRemoved: true,
+		},
+		{
+			name:     "tombstone",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withActors},
+			iri:      jdoe.ID,
+			opts:     DeleteActorOptions{Tombstone: true},
+			wantRows: map[string]int64{"actors": 1, "objects": 1, "activities": 1, "collections": 1, "collection_items": 1, "meta": 1},
+			wantType: vocab.TombstoneType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mockRepo(t, fields{path: t.TempDir()}, tt.setupFns...)
+			t.Cleanup(r.Close)
+
+			report, err := r.DeleteActor(context.Background(), tt.iri, tt.opts)
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Fatalf("DeleteActor() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			slices.Sort(report.Items)
+			slices.Sort(wantItems)
+			be.AllEqual(t, wantItems, report.Items)
+			if !cmp.Equal(tt.wantRows, report.Rows) {
+				t.Errorf("DeleteActor() rows differ %s", cmp.Diff(tt.wantRows, report.Rows))
+			}
+
+			it, err := r.Load(jdoe.ID)
+			if tt.wantRemoved {
+				if !errors.IsNotFound(err) {
+					t.Errorf("Load() expected not found error for removed actor, received %v", err)
+				}
+			} else {
+				be.NilErr(t, err)
+				be.Equal(t, tt.wantType, it.GetType())
+			}
+
+			for _, table := range []string{"access", "authorize"} {
+				var tokens int
+				be.NilErr(t, r.ro.QueryRow("SELECT count(*) FROM "+table+" WHERE CAST(extra AS TEXT) = ?;", jdoe.ID).Scan(&tokens))
+				be.Equal(t, 0, tokens)
+			}
+
+			// the second actor and its collection are not affected
+			_, err = r.Load(jdoe2.ID)
+			be.NilErr(t, err)
+			var count int
+			be.NilErr(t, r.ro.QueryRow("SELECT count(*) FROM collections WHERE iri = ?;", outbox2.ID).Scan(&count))
+			be.Equal(t, 1, count)
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"iter"
+	"math"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+// ChangeType is the type of the operation that changed the stored items.
+type ChangeType string
+
+const (
+	// ChangeSave is logged when an item is saved.
+	ChangeSave ChangeType = "save"
+	// ChangeDelete is logged when an item is deleted.
+	ChangeDelete ChangeType = "delete"
+	// ChangeAdd is logged when an item is added to a collection.
+	ChangeAdd ChangeType = "add"
+	// ChangeRemove is logged when an item is removed from a collection.
+	ChangeRemove ChangeType = "remove"
+)
+
+// ChangeEvent is an entry of the changes log.
+type ChangeEvent struct {
+	// Seq is the sequence number of the change, it increases monotonically.
+	Seq int64
+	// Type is the type of the change.
+	Type ChangeType
+	// IRI is the IRI of the changed item, or of the collection for ChangeAdd and ChangeRemove.
+	IRI vocab.IRI
+	// Item is the IRI of the item added to, or removed from the collection, for ChangeAdd and ChangeRemove.
+	Item vocab.IRI
+	// Published is the time when the change was made.
+	Published time.Time
+}
+
+const (
+	defaultChangesPollInterval = time.Second
+	changesPageSize            = 100
+
+	// timestampLayout is the format of the SQLite CURRENT_TIMESTAMP values.
+	timestampLayout = "2006-01-02 15:04:05"
+)
+
+// Subscribe returns an iterator over the changes made to the stored items, with a sequence number
+// larger than sinceSeq. A negative sinceSeq starts the iteration after the latest change.
+//
+// After reaching the end of the changes log, the iterator waits for new changes, checking the database
+// every Config.ChangesPollInterval, so it sees the changes made by other processes too.
+// The iteration stops when ctx is done, or on the first error. Workers can resume it after a restart
+// by passing the Seq of the last event they processed.
+func (r *repo) Subscribe(ctx context.Context, sinceSeq int64) iter.Seq2[ChangeEvent, error] {
+	return func(yield func(ChangeEvent, error) bool) {
+		if r == nil || r.ro == nil {
+			yield(ChangeEvent{}, errNotOpen)
+			return
+		}
+		if sinceSeq < 0 {
+			var err error
+			if sinceSeq, err = r.lastChangeSeq(ctx); err != nil {
+				yield(ChangeEvent{}, err)
+				return
+			}
+		}
+
+		interval := r.changesPollInterval
+		if interval <= 0 {
+			interval = defaultChangesPollInterval
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+			changes, err := r.loadChanges(ctx, sinceSeq, changesPageSize)
+			if err != nil {
+				if ctx.Err() == nil {
+					yield(ChangeEvent{}, err)
+				}
+				return
+			}
+			for _, c := range changes {
+				if !yield(c, nil) {
+					return
+				}
+				sinceSeq = c.Seq
+			}
+			if len(changes) == changesPageSize {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
+// PruneChanges removes from the changes log the entries older than before.
+// It returns the number of entries removed.
+//
+// When the cache invalidation is running, the entries it has not checked yet are kept.
+// The Subscribe iterators resuming from a sequence number that was pruned don't receive the removed entries,
+// so before should be older than the last change their workers have processed.
+// See RetentionPolicy.ChangesMaxAge for pruning the log periodically.
+func (r *repo) PruneChanges(ctx context.Context, before time.Time) (int64, error) {
+	if r == nil || r.conn == nil {
+		return 0, errNotOpen
+	}
+	maxSeq := int64(math.MaxInt64)
+	if r.invalidator != nil {
+		maxSeq = r.invalidator.lastSeq.Load()
+	}
+	query := "DELETE FROM changes WHERE published < ? AND seq <= ?;"
+	res, err := r.exec(ctx, query, before.UTC().Format(timestampLayout), maxSeq)
+	if err != nil {
+		return 0, wrapSQLError(err, "unable to prune changes")
+	}
+	return res.RowsAffected()
+}
+
+func (r *repo) lastChangeSeq(ctx context.Context) (int64, error) {
+	return loadLastChangeSeq(ctx, r.ro)
+}
+
+func loadLastChangeSeq(ctx context.Context, q querier) (int64, error) {
+	var seq int64
+	if err := q.QueryRowContext(ctx, "SELECT coalesce(max(seq), 0) FROM changes;").Scan(&seq); err != nil {
+		return 0, wrapSQLError(err, "unable to load the last change")
+	}
+	return seq, nil
+}
+
+const selectChanges = "SELECT seq, type, iri, item, published FROM changes WHERE seq > ? ORDER BY seq LIMIT ?;"
+
+func (r *repo) loadChanges(ctx context.Context, sinceSeq int64, limit int) ([]ChangeEvent, error) {
+	rows, err := r.ro.QueryContext(ctx, selectChanges, sinceSeq, limit)
+	if err != nil {
+		return nil, wrapSQLError(err, "unable to load changes")
+	}
+	defer rows.Close()
+
+	changes := make([]ChangeEvent, 0, limit)
+	for rows.Next() {
+		var c ChangeEvent
+		var item sql.NullString
+		var published string
+		if err = rows.Scan(&c.Seq, &c.Type, &c.IRI, &item, &published); err != nil {
+			return nil, wrapSQLError(err, "scan values error")
+		}
+		c.Item = vocab.IRI(item.String)
+		c.Published, _ = time.Parse(timestampLayout, published)
+		changes = append(changes, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, wrapSQLError(err, "unable to load changes")
+	}
+	return changes, nil
+}
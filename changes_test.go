@@ -0,0 +1,138 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func Test_repo_Subscribe(t *testing.T) {
+	ob := &vocab.Object{ID: "https://example.com/1", Type: vocab.NoteType}
+	outbox := &vocab.OrderedCollection{ID: "https://example.com/~jdoe/outbox", Type: vocab.OrderedCollectionType}
+
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap)
+	t.Cleanup(r.Close)
+	r.changesPollInterval = 10 * time.Millisecond
+
+	_, err := r.Save(outbox)
+	be.NilErr(t, err)
+	_, err = r.Save(ob)
+	be.NilErr(t, err)
+	be.NilErr(t, r.AddTo(outbox.ID, ob))
+	be.NilErr(t, r.RemoveFrom(outbox.ID, ob))
+	be.NilErr(t, r.Delete(ob))
+
+	// NOTE(marius): the changes to the collection object itself, which are made every time its items
+	// are modified, are skipped
+	want := []ChangeEvent{
+		{Type: ChangeSave, IRI: ob.ID},
+		{Type: ChangeAdd, IRI: outbox.ID, Item: ob.ID},
+		{Type: ChangeRemove, IRI: outbox.ID, Item: ob.ID},
+		{Type: ChangeDelete, IRI: ob.ID},
+	}
+	ignoreFields := cmpopts.IgnoreFields(ChangeEvent{}, "Seq", "Published")
+
+	subscribe := func(sinceSeq int64, count int) []ChangeEvent {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		got := make([]ChangeEvent, 0)
+		for ev, err := range r.Subscribe(ctx, sinceSeq) {
+			be.NilErr(t, err)
+			if ev.Type == ChangeSave && ev.IRI == outbox.ID {
+				continue
+			}
+			be.True(t, ev.Seq > sinceSeq)
+			be.True(t, !ev.Published.IsZero())
+			if got = append(got, ev); len(got) == count {
+				break
+			}
+		}
+		return got
+	}
+
+	got := subscribe(0, len(want))
+	if !cmp.Equal(want, got, ignoreFields) {
+		t.Errorf("Subscribe() events differ %s", cmp.Diff(want, got, ignoreFields))
+	}
+	for i := 1; i < len(got); i++ {
+		be.True(t, got[i].Seq > got[i-1].Seq)
+	}
+
+	// resuming after the second event
+	resumed := subscribe(got[1].Seq, len(want)-2)
+	if !cmp.Equal(want[2:], resumed, ignoreFields) {
+		t.Errorf("Subscribe() resumed events differ %s", cmp.Diff(want[2:], resumed, ignoreFields))
+	}
+
+	// starting after the latest change, waits for new ones until the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	for ev, err := range r.Subscribe(ctx, -1) {
+		t.Errorf("Subscribe() from the latest change returned unexpected event %v, %v", ev, err)
+	}
+}
+
+func Test_repo_Subscribe_notOpen(t *testing.T) {
+	r := mockRepo(t, fields{path: t.TempDir()})
+	for _, err := range r.Subscribe(context.Background(), 0) {
+		be.Equal(t, errNotOpen, err)
+	}
+}
+
+func Test_repo_PruneChanges(t *testing.T) {
+	ob := &vocab.Object{ID: "https://example.com/1", Type: vocab.NoteType}
+
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap)
+	t.Cleanup(r.Close)
+
+	_, err := r.Save(ob)
+	be.NilErr(t, err)
+
+	ctx := context.Background()
+	n, err := r.PruneChanges(ctx, time.Now().Add(-time.Hour))
+	be.NilErr(t, err)
+	be.Equal(t, int64(0), n)
+
+	n, err = r.PruneChanges(ctx, time.Now().Add(time.Hour))
+	be.NilErr(t, err)
+	be.True(t, n > 0)
+
+	changes, err := r.loadChanges(ctx, 0, changesPageSize)
+	be.NilErr(t, err)
+	be.Equal(t, 0, len(changes))
+}
+
+func Test_repo_PruneChanges_keepsUncheckedChanges(t *testing.T) {
+	ctx := context.Background()
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap)
+	t.Cleanup(r.Close)
+
+	_, err := r.Save(&vocab.Object{ID: "https://example.com/1", Type: vocab.NoteType})
+	be.NilErr(t, err)
+	checked, err := r.lastChangeSeq(ctx)
+	be.NilErr(t, err)
+	_, err = r.Save(&vocab.Object{ID: "https://example.com/2", Type: vocab.NoteType})
+	be.NilErr(t, err)
+
+	// NOTE(marius): the cache invalidation has checked the changes up to the first save
+	done := make(chan struct{})
+	close(done)
+	r.invalidator = &cacheInvalidator{stop: func() {}, done: done}
+	r.invalidator.lastSeq.Store(checked)
+
+	_, err = r.PruneChanges(ctx, time.Now().Add(time.Hour))
+	be.NilErr(t, err)
+
+	changes, err := r.loadChanges(ctx, 0, changesPageSize)
+	be.NilErr(t, err)
+	be.True(t, len(changes) > 0)
+	for _, c := range changes {
+		be.True(t, c.Seq > checked)
+	}
+}
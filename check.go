@@ -0,0 +1,311 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// CheckOptions configures Check.
+type CheckOptions struct {
+	// Repair fixes the issues found by the ActivityPub consistency checks, when possible:
+	// the totalItems of the collections get recounted, and the collection items that reference
+	// missing IRIs, or belong to missing collections, get removed. The issues found by the SQLite checks
+	// are only reported.
+	Repair bool
+}
+
+// IssueType identifies an ActivityPub consistency check.
+type IssueType string
+
+const (
+	// IssueTotalItems is a collection whose totalItems is different from the number of its items.
+	IssueTotalItems IssueType = "totalItems"
+	// IssueMissingItem is a collection item that is missing from the objects, activities, actors and collections tables.
+	IssueMissingItem IssueType = "missingItem"
+	// IssueOrphanedItem is a collection item whose collection is missing from the collections table.
+	IssueOrphanedItem IssueType = "orphanedItem"
+	// IssueMissingActor is an activity whose actor is missing from the actors table.
+	IssueMissingActor IssueType = "missingActor"
+	// IssueMissingObject is an activity whose object is missing from the objects, activities, actors and collections tables.
+	IssueMissingObject IssueType = "missingObject"
+)
+
+// CheckIssue is an inconsistency found by Check.
+type CheckIssue struct {
+	Type IssueType
+	// IRI is the collection or activity that has the issue.
+	IRI vocab.IRI
+	// Ref is the missing IRI that is referenced, it's empty for IssueTotalItems.
+	Ref vocab.IRI
+	// Detail describes the issue.
+	Detail string
+	// Repaired is set when the issue was fixed.
+	Repaired bool
+}
+
+// ForeignKeyViolation is a row reported by "PRAGMA foreign_key_check".
+type ForeignKeyViolation struct {
+	Table  string
+	RowID  int64
+	Parent string
+}
+
+// CheckReport holds the results of Check.
+type CheckReport struct {
+	// Integrity holds the errors reported by "PRAGMA integrity_check".
+	Integrity []string
+	// ForeignKeys holds the rows reported by "PRAGMA foreign_key_check".
+	ForeignKeys []ForeignKeyViolation
+	// Issues holds the inconsistencies found by the ActivityPub checks.
+	Issues []CheckIssue
+}
+
+// OK returns true if none of the checks found any problems, or all the issues found were repaired.
+func (c CheckReport) OK() bool {
+	if len(c.Integrity) > 0 || len(c.ForeignKeys) > 0 {
+		return false
+	}
+	for _, i := range c.Issues {
+		if !i.Repaired {
+			return false
+		}
+	}
+	return true
+}
+
+// Check verifies the consistency of the database. It runs the SQLite integrity and foreign key checks,
+// and looks for collections with wrong totalItems, collection items that reference missing IRIs,
+// collection items of missing collections, and activities whose actor or object are missing.
+//
+// NOTE(marius): the foreign key check reports also the activities whose object is an activity or an actor,
+// as the activities_objects_iri_fk constraint only references the objects table.
+func (r *repo) Check(ctx context.Context, opts CheckOptions) (CheckReport, error) {
+	report := CheckReport{}
+	if r == nil || r.conn == nil {
+		return report, errNotOpen
+	}
+
+	var err error
+	if report.Integrity, err = integrityCheck(ctx, r.reader()); err != nil {
+		return report, err
+	}
+	if report.ForeignKeys, err = foreignKeyCheck(ctx, r.reader()); err != nil {
+		return report, err
+	}
+
+	if !opts.Repair {
+		report.Issues, err = consistencyCheck(ctx, r.reader())
+		return report, err
+	}
+
+	// NOTE(marius): in repair mode the checks run in the same transaction as the fixes,
+	// so we don't act on issues that have been fixed in the meantime by other writers.
+	var changed vocab.IRIs
+	err = r.writeTx(ctx, func(tx *sql.Tx) error {
+		issues, err := consistencyCheck(ctx, tx)
+		if err != nil {
+			return err
+		}
+		changed, err = r.repairIssues(ctx, tx, issues)
+		report.Issues = issues
+		return err
+	})
+	if err != nil {
+		return CheckReport{Integrity: report.Integrity, ForeignKeys: report.ForeignKeys}, err
+	}
+	if r.cache != nil {
+		for _, iri := range changed {
+			r.cache.Delete(iri)
+		}
+	}
+	return report, nil
+}
+
+func integrityCheck(ctx context.Context, q querier) ([]string, error) {
+	rows, err := q.QueryContext(ctx, "PRAGMA integrity_check;")
+	if err != nil {
+		return nil, wrapSQLError(err, "unable to run integrity check")
+	}
+	defer rows.Close()
+
+	var errs []string
+	for rows.Next() {
+		var msg string
+		if err = rows.Scan(&msg); err != nil {
+			return nil, wrapSQLError(err, "scan values error")
+		}
+		if msg != "ok" {
+			errs = append(errs, msg)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, wrapSQLError(err, "unable to run integrity check")
+	}
+	return errs, nil
+}
+
+func foreignKeyCheck(ctx context.Context, q querier) ([]ForeignKeyViolation, error) {
+	rows, err := q.QueryContext(ctx, "PRAGMA foreign_key_check;")
+	if err != nil {
+		return nil, wrapSQLError(err, "unable to run foreign key check")
+	}
+	defer rows.Close()
+
+	var violations []ForeignKeyViolation
+	for rows.Next() {
+		var table, parent string
+		var rowID sql.NullInt64
+		var fkID int
+		if err = rows.Scan(&table, &rowID, &parent, &fkID); err != nil {
+			return nil, wrapSQLError(err, "scan values error")
+		}
+		violations = append(violations, ForeignKeyViolation{Table: table, RowID: rowID.Int64, Parent: parent})
+	}
+	if err = rows.Err(); err != nil {
+		return nil, wrapSQLError(err, "unable to run foreign key check")
+	}
+	return violations, nil
+}
+
+// storedIRI is the condition for an IRI being present in one of the tables that hold items.
+const storedIRI = `EXISTS (SELECT 1 FROM objects WHERE iri = %[1]s) OR EXISTS (SELECT 1 FROM activities WHERE iri = %[1]s)
+	OR EXISTS (SELECT 1 FROM actors WHERE iri = %[1]s) OR EXISTS (SELECT 1 FROM collections WHERE iri = %[1]s)`
+
+var (
+	checkTotalItemsQ = `SELECT c.iri, coalesce(json_extract(c.raw, '$.totalItems'), 0) AS total,
+	(SELECT count(*) FROM collection_items ci WHERE ci.collection_iri = c.iri) AS items_count
+	FROM collections c WHERE total != items_count ORDER BY c.iri;`
+
+	checkMissingItemsQ = `SELECT collection_iri, item_iri FROM collection_items ci
+	WHERE NOT (` + fmt.Sprintf(storedIRI, "ci.item_iri") + `) ORDER BY collection_iri, position;`
+
+	checkOrphanedItemsQ = `SELECT collection_iri, item_iri FROM collection_items ci
+	WHERE NOT EXISTS (SELECT 1 FROM collections WHERE iri = ci.collection_iri) ORDER BY collection_iri, position;`
+
+	checkMissingActorsQ = `SELECT a.iri, a.actor FROM activities a
+	WHERE json_type(a.raw, '$.actor') = 'text' AND NOT EXISTS (SELECT 1 FROM actors WHERE iri = a.actor) ORDER BY a.iri;`
+
+	checkMissingObjectsQ = `SELECT a.iri, a.object FROM activities a
+	WHERE json_type(a.raw, '$.object') = 'text' AND NOT (` + fmt.Sprintf(storedIRI, "a.object") + `) ORDER BY a.iri;`
+)
+
+// consistencyCheck runs the ActivityPub level checks.
+func consistencyCheck(ctx context.Context, q querier) ([]CheckIssue, error) {
+	issues := make([]CheckIssue, 0)
+
+	rows, err := q.QueryContext(ctx, checkTotalItemsQ)
+	if err != nil {
+		return nil, wrapSQLError(err, "unable to check collections totalItems")
+	}
+	for rows.Next() {
+		var iri vocab.IRI
+		var total, count int64
+		if err = rows.Scan(&iri, &total, &count); err != nil {
+			rows.Close()
+			return nil, wrapSQLError(err, "scan values error")
+		}
+		// NOTE(marius): the items of the storage collections are the rows of their tables
+		if isStorageCollectionIRI(iri) {
+			continue
+		}
+		issues = append(issues, CheckIssue{
+			Type:   IssueTotalItems,
+			IRI:    iri,
+			Detail: fmt.Sprintf("totalItems is %d, the collection has %d items", total, count),
+		})
+	}
+	if err = closeRows(rows); err != nil {
+		return nil, wrapSQLError(err, "unable to check collections totalItems")
+	}
+
+	refChecks := []struct {
+		typ    IssueType
+		query  string
+		detail string
+	}{
+		{typ: IssueMissingItem, query: checkMissingItemsQ, detail: "collection item %s is not stored"},
+		{typ: IssueOrphanedItem, query: checkOrphanedItemsQ, detail: "the collection of item %s is not stored"},
+		{typ: IssueMissingActor, query: checkMissingActorsQ, detail: "activity actor %s is not stored"},
+		{typ: IssueMissingObject, query: checkMissingObjectsQ, detail: "activity object %s is not stored"},
+	}
+	for _, c := range refChecks {
+		rows, err = q.QueryContext(ctx, c.query)
+		if err != nil {
+			return nil, wrapSQLError(err, "unable to run %s check", c.typ)
+		}
+		for rows.Next() {
+			var iri, ref vocab.IRI
+			if err = rows.Scan(&iri, &ref); err != nil {
+				rows.Close()
+				return nil, wrapSQLError(err, "scan values error")
+			}
+			issues = append(issues, CheckIssue{Type: c.typ, IRI: iri, Ref: ref, Detail: fmt.Sprintf(c.detail, ref)})
+		}
+		if err = closeRows(rows); err != nil {
+			return nil, wrapSQLError(err, "unable to run %s check", c.typ)
+		}
+	}
+	return issues, nil
+}
+
+func closeRows(rows *sql.Rows) error {
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	return rows.Close()
+}
+
+// repairIssues fixes the issues that can be repaired, marking them as such, and returns the IRIs of
+// the collections that were changed.
+func (r *repo) repairIssues(ctx context.Context, tx *sql.Tx, issues []CheckIssue) (vocab.IRIs, error) {
+	changed := make(vocab.IRIs, 0)
+	for _, issue := range issues {
+		if issue.Type != IssueMissingItem && issue.Type != IssueTotalItems {
+			continue
+		}
+		if !slices.Contains(changed, issue.IRI) {
+			changed = append(changed, issue.IRI)
+		}
+	}
+
+	// NOTE(marius): the collections are loaded before removing their missing items, so the items still
+	// embedded in their raw values are moved to the collection_items table first, and can't bring them back.
+	loaded := make([]vocab.Item, 0, len(changed))
+	for _, col := range changed {
+		c, err := r.loadCollectionForUpdate(ctx, tx, col)
+		if err != nil {
+			// NOTE(marius): the items of a missing collection are removed as orphaned
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			return changed, wrapSQLError(err, "unable to load collection %s", col)
+		}
+		loaded = append(loaded, c)
+	}
+
+	for i, issue := range issues {
+		switch issue.Type {
+		case IssueMissingItem, IssueOrphanedItem:
+			query := "DELETE FROM collection_items WHERE collection_iri = ? AND item_iri = ?;"
+			if _, err := tx.ExecContext(ctx, query, issue.IRI, issue.Ref); err != nil {
+				return changed, wrapSQLError(err, "unable to remove %s from %s", issue.Ref, issue.IRI)
+			}
+			issues[i].Repaired = true
+		case IssueTotalItems:
+			issues[i].Repaired = true
+		}
+	}
+
+	for _, c := range loaded {
+		if err := r.updateCollectionTotalItems(ctx, tx, c); err != nil {
+			return changed, err
+		}
+	}
+	return changed, nil
+}
@@ -0,0 +1,141 @@
+package sqlite
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_repo_Check(t *testing.T) {
+	outbox := &vocab.OrderedCollection{ID: "https://example.com/actors/1/outbox", Type: vocab.OrderedCollectionType}
+	liked := &vocab.OrderedCollection{ID: "https://example.com/actors/1/liked", Type: vocab.OrderedCollectionType}
+	note := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType}
+	missing := vocab.IRI("https://example.com/objects/missing")
+	missingActor := vocab.IRI("https://example.com/actors/missing")
+	like := &vocab.Activity{ID: "https://example.com/activities/1", Type: vocab.LikeType, Actor: missingActor, Object: missing}
+
+	withInconsistencies := func(t *testing.T, r *repo) *repo {
+		withGeneratedItems(vocab.ItemCollection{outbox, liked, note, like})(t, r)
+		be.NilErr(t, r.AddTo(outbox.ID, note))
+		_, err := r.conn.Exec("INSERT INTO collection_items (collection_iri, item_iri, position) VALUES (?, ?, 100);", outbox.ID, missing)
+		be.NilErr(t, err)
+		_, err = r.conn.Exec("UPDATE collections SET raw = json_set(raw, '$.totalItems', 5) WHERE iri = ?;", liked.ID)
+		be.NilErr(t, err)
+		return r
+	}
+	// NOTE(marius): the collection still has its items embedded in its raw value
+	embedded := &vocab.OrderedCollection{
+		ID:           "https://example.com/actors/1/following",
+		Type:         vocab.OrderedCollectionType,
+		OrderedItems: vocab.ItemCollection{missing},
+	}
+	withEmbeddedItems := func(t *testing.T, r *repo) *repo {
+		withGeneratedItems(vocab.ItemCollection{embedded})(t, r)
+		_, err := r.conn.Exec("INSERT INTO collection_items (collection_iri, item_iri, position) VALUES (?, ?, 1);", embedded.ID, missing)
+		be.NilErr(t, err)
+		return r
+	}
+
+	gone := vocab.IRI("https://example.com/actors/1/gone")
+	withOrphanedItems := func(t *testing.T, r *repo) *repo {
+		query := "INSERT INTO collection_items (collection_iri, item_iri, position) VALUES (?, ?, 1), (?, ?, 2);"
+		_, err := r.conn.Exec(query, gone, note.ID, gone, missing)
+		be.NilErr(t, err)
+		return r
+	}
+
+	wantIssues := []CheckIssue{
+		{Type: IssueTotalItems, IRI: outbox.ID},
+		{Type: IssueTotalItems, IRI: liked.ID},
+		{Type: IssueMissingItem, IRI: outbox.ID, Ref: missing},
+		{Type: IssueMissingActor, IRI: like.ID, Ref: missingActor},
+		{Type: IssueMissingObject, IRI: like.ID, Ref: missing},
+	}
+	wantRepaired := map[IssueType]bool{IssueTotalItems: true, IssueMissingItem: true, IssueOrphanedItem: true}
+
+	tests := []struct {
+		name     string
+		setupFns []initFn
+		opts     CheckOptions
+		want     []CheckIssue
+		wantErr  error
+	}{
+		{
+			name:    "not open",
+			wantErr: errNotOpen,
+		},
+		{
+			name:     "report",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withInconsistencies},
+			want:     wantIssues,
+		},
+		{
+			name:     "repair",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withInconsistencies},
+			opts:     CheckOptions{Repair: true},
+			want:     wantIssues,
+		},
+		{
+			name:     "repair, with embedded items",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withInconsistencies, withEmbeddedItems},
+			opts:     CheckOptions{Repair: true},
+			want:     append(slices.Clone(wantIssues), CheckIssue{Type: IssueMissingItem, IRI: embedded.ID, Ref: missing}),
+		},
+		{
+			name:     "repair, with orphaned items",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withInconsistencies, withOrphanedItems},
+			opts:     CheckOptions{Repair: true},
+			want: append(slices.Clone(wantIssues),
+				CheckIssue{Type: IssueMissingItem, IRI: gone, Ref: missing},
+				CheckIssue{Type: IssueOrphanedItem, IRI: gone, Ref: note.ID},
+				CheckIssue{Type: IssueOrphanedItem, IRI: gone, Ref: missing},
+			),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mockRepo(t, fields{path: t.TempDir()}, tt.setupFns...)
+			t.Cleanup(r.Close)
+
+			report, err := r.Check(context.Background(), tt.opts)
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Fatalf("Check() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			be.Equal(t, 0, len(report.Integrity))
+			be.False(t, report.OK())
+
+			for _, want := range tt.want {
+				i := slices.IndexFunc(report.Issues, func(i CheckIssue) bool {
+					return i.Type == want.Type && i.IRI == want.IRI && i.Ref == want.Ref
+				})
+				if i < 0 {
+					t.Errorf("Check() issue %s for %s was not reported", want.Type, want.IRI)
+					continue
+				}
+				be.Equal(t, tt.opts.Repair && wantRepaired[want.Type], report.Issues[i].Repaired)
+			}
+			if !tt.opts.Repair {
+				return
+			}
+
+			// NOTE(marius): after the repair, only the issues that can't be fixed are still reported
+			report, err = r.Check(context.Background(), CheckOptions{})
+			be.NilErr(t, err)
+			for _, i := range report.Issues {
+				if wantRepaired[i.Type] {
+					t.Errorf("Check() issue %s for %s was still reported after repair", i.Type, i.IRI)
+				}
+			}
+			cols, err := r.CollectionsContaining(context.Background(), missing)
+			be.NilErr(t, err)
+			be.Equal(t, 0, len(cols))
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package sqlite
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/go-ap/errors"
+)
+
+// CheckpointMode is the mode in which the WAL file gets checkpointed into the database.
+// See https://www.sqlite.org/pragma.html#pragma_wal_checkpoint for details.
+type CheckpointMode string
+
+const (
+	// CheckpointPassive checkpoints as many frames as possible without waiting for readers or writers.
+	CheckpointPassive CheckpointMode = "PASSIVE"
+	// CheckpointFull waits for the writers, and then checkpoints all the frames.
+	CheckpointFull CheckpointMode = "FULL"
+	// CheckpointRestart works like CheckpointFull, and then waits for the readers so the next writer can
+	// start from the beginning of the WAL file.
+	CheckpointRestart CheckpointMode = "RESTART"
+	// CheckpointTruncate works like CheckpointRestart, and then truncates the WAL file to zero bytes.
+	CheckpointTruncate CheckpointMode = "TRUNCATE"
+)
+
+var checkpointModes = []CheckpointMode{CheckpointPassive, CheckpointFull, CheckpointRestart, CheckpointTruncate}
+
+// defaultCheckpointCheckInterval is how often the size of the WAL file gets checked when the CheckpointPolicy
+// has only a MaxWALSize.
+const defaultCheckpointCheckInterval = time.Minute
+
+// CheckpointPolicy configures the background checkpoints of the WAL file.
+// When both Interval and MaxWALSize are zero, no background checkpoints are run.
+type CheckpointPolicy struct {
+	// Interval is how often the WAL file gets checkpointed.
+	// When MaxWALSize is also set, it is how often the size of the WAL file gets checked.
+	Interval time.Duration
+	// MaxWALSize is the size in bytes of the WAL file above which it gets checkpointed.
+	MaxWALSize int64
+	// Mode is the mode of the background checkpoints, it defaults to CheckpointPassive.
+	Mode CheckpointMode
+}
+
+func (p CheckpointPolicy) enabled() bool {
+	return p.Interval > 0 || p.MaxWALSize > 0
+}
+
+// checkpointer holds the state of the goroutine running the background checkpoints.
+type checkpointer struct {
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// Checkpoint transfers the content of the WAL file into the database, using mode.
+func (r *repo) Checkpoint(ctx context.Context, mode CheckpointMode) error {
+	if r == nil || r.conn == nil {
+		return errNotOpen
+	}
+	valid := false
+	for _, m := range checkpointModes {
+		valid = valid || m == mode
+	}
+	if !valid {
+		return errors.Newf("invalid checkpoint mode %q", mode)
+	}
+
+	var busy, logFrames, checkpointed int
+	// NOTE(marius): pragma statements don't support parameters
+	err := r.conn.QueryRowContext(ctx, "PRAGMA wal_checkpoint("+string(mode)+");").Scan(&busy, &logFrames, &checkpointed)
+	if err != nil {
+		return wrapSQLError(err, "unable to run %s checkpoint", mode)
+	}
+	if busy != 0 {
+		return errors.Newf("%s checkpoint could not complete as the database is busy", mode)
+	}
+	r.logFn("%s checkpoint: %d of %d WAL frames", mode, checkpointed, logFrames)
+	return nil
+}
+
+// startCheckpointer starts the goroutine that checkpoints the WAL file according to the checkpoint policy.
+func (r *repo) startCheckpointer() {
+	if !r.checkpointPolicy.enabled() || r.checkpointer != nil {
+		return
+	}
+
+	interval := r.checkpointPolicy.Interval
+	if interval <= 0 {
+		interval = defaultCheckpointCheckInterval
+	}
+	mode := r.checkpointPolicy.Mode
+	if mode == "" {
+		mode = CheckpointPassive
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	c := checkpointer{stop: stop, done: make(chan struct{})}
+	r.checkpointer = &c
+
+	go func() {
+		defer close(c.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !r.walAboveSize(r.checkpointPolicy.MaxWALSize) {
+					continue
+				}
+				if err := r.Checkpoint(ctx, mode); err != nil && !errors.Is(err, context.Canceled) {
+					r.errFn("%s", errors.Annotatef(err, "background checkpoint error"))
+				}
+			}
+		}
+	}()
+}
+
+// stopCheckpointer stops the background checkpoints goroutine, and waits for it to finish.
+func (r *repo) stopCheckpointer() {
+	if r.checkpointer == nil {
+		return
+	}
+	r.checkpointer.stop()
+	<-r.checkpointer.done
+	r.checkpointer = nil
+}
+
+func (r *repo) walAboveSize(size int64) bool {
+	if size <= 0 {
+		return true
+	}
+	fi, err := os.Stat(r.path + "-wal")
+	if err != nil {
+		return false
+	}
+	return fi.Size() > size
+}
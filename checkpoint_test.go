@@ -0,0 +1,130 @@
+package sqlite
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+func walSize(t *testing.T, r *repo) int64 {
+	fi, err := os.Stat(r.path + "-wal")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0
+		}
+		t.Fatalf("unable to stat WAL file: %s", err)
+	}
+	return fi.Size()
+}
+
+func Test_repo_Checkpoint(t *testing.T) {
+	ob := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType}
+
+	tests := []struct {
+		name     string
+		setupFns []initFn
+		mode     CheckpointMode
+		wantErr  error
+		wantWAL  bool
+	}{
+		{
+			name:    "not open",
+			mode:    CheckpointPassive,
+			wantErr: errNotOpen,
+		},
+		{
+			name:     "invalid mode",
+			setupFns: []initFn{withOpenRoot, withBootstrap},
+			mode:     "TEST",
+			wantErr:  errors.Newf("invalid checkpoint mode %q", "TEST"),
+		},
+		{
+			name:     "passive",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{ob})},
+			mode:     CheckpointPassive,
+			wantWAL:  true,
+		},
+		{
+			name:     "full",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{ob})},
+			mode:     CheckpointFull,
+			wantWAL:  true,
+		},
+		{
+			name:     "restart",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{ob})},
+			mode:     CheckpointRestart,
+			wantWAL:  true,
+		},
+		{
+			name:     "truncate",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{ob})},
+			mode:     CheckpointTruncate,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mockRepo(t, fields{path: t.TempDir()}, tt.setupFns...)
+			t.Cleanup(r.Close)
+
+			err := r.Checkpoint(context.Background(), tt.mode)
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Errorf("Checkpoint() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+				return
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if !tt.wantWAL {
+				be.Equal(t, int64(0), walSize(t, r))
+			}
+			// NOTE(marius): the checkpointed data needs to be readable
+			it, err := r.Load(ob.ID)
+			be.NilErr(t, err)
+			be.Equal(t, ob.ID, it.GetLink())
+		})
+	}
+}
+
+func Test_repo_startCheckpointer(t *testing.T) {
+	conf := Config{
+		Path:  t.TempDir(),
+		LogFn: t.Logf,
+		ErrFn: t.Errorf,
+		Checkpoint: CheckpointPolicy{
+			Interval:   10 * time.Millisecond,
+			MaxWALSize: 1,
+			Mode:       CheckpointTruncate,
+		},
+	}
+	be.NilErr(t, Bootstrap(conf))
+
+	r, err := New(conf)
+	be.NilErr(t, err)
+	be.NilErr(t, r.Open())
+	t.Cleanup(r.Close)
+
+	if r.checkpointer == nil {
+		t.Fatalf("Open() did not start the background checkpoints")
+	}
+
+	_, err = r.Save(&vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType})
+	be.NilErr(t, err)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for walSize(t, r) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	be.Equal(t, int64(0), walSize(t, r))
+
+	r.Close()
+	if r.checkpointer != nil {
+		t.Errorf("Close() did not stop the background checkpoints")
+	}
+}
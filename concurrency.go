@@ -0,0 +1,111 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// SaveIfUnchanged saves it only if the stored version was not modified since expectedUpdated.
+func (r *repo) SaveIfUnchanged(it vocab.Item, expectedUpdated time.Time) (vocab.Item, error) {
+	return r.SaveIfUnchangedContext(context.Background(), it, expectedUpdated)
+}
+
+// SaveIfUnchangedContext saves it only if the "updated" value of the stored version, which falls back
+// to its "deleted" or "published" values, is expectedUpdated. Otherwise, it returns a conflict error.
+// A zero expectedUpdated means that the item is expected to not be stored yet, or to have none of these values.
+// When the item is already stored, the "updated" value of it must be later than the one of the stored version,
+// otherwise it also returns a conflict error, so every save changes the value that the next one expects.
+//
+// The check and the save are done in the same transaction, so concurrent calls can't overwrite each
+// other's changes.
+func (r *repo) SaveIfUnchangedContext(ctx context.Context, it vocab.Item, expectedUpdated time.Time) (vocab.Item, error) {
+	if r == nil || r.conn == nil {
+		return nil, errNotOpen
+	}
+	if vocab.IsNil(it) {
+		return nil, errNilItem
+	}
+
+	var saved vocab.Item
+	err := r.writeTx(ctx, func(tx *sql.Tx) error {
+		if err := checkUnchanged(ctx, tx, it, expectedUpdated); err != nil {
+			return err
+		}
+		var err error
+		saved, err = r.save(ctx, tx, it)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if r.cache != nil {
+		r.cache.Store(saved.GetLink(), saved)
+	}
+	return saved, nil
+}
+
+// checkUnchanged returns a conflict error if the updated value of the stored version of it differs from expected.
+func checkUnchanged(ctx context.Context, tx *sql.Tx, it vocab.Item, expected time.Time) error {
+	iri := it.GetLink()
+
+	var updated sql.NullString
+	sel := "SELECT updated FROM " + tableForItem(it) + " WHERE iri = ?;"
+	err := tx.QueryRowContext(ctx, sel, iri).Scan(&updated)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return wrapSQLError(err, "unable to load the stored version of %s", iri)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		if !expected.IsZero() {
+			return errors.Conflictf("%s is not stored, expected it to be updated at %s", iri, expected.Format(time.RFC3339))
+		}
+		return nil
+	}
+
+	var current time.Time
+	if updated.Valid {
+		if current, err = time.Parse(time.RFC3339Nano, updated.String); err != nil {
+			return errors.Annotatef(err, "invalid updated value %q for %s", updated.String, iri)
+		}
+	}
+	// NOTE(marius): the times are serialized with a precision of one second
+	current = current.Truncate(time.Second)
+	if !current.Equal(expected.Truncate(time.Second)) {
+		return errors.Conflictf("%s has been updated at %s, expected %s", iri, current.Format(time.RFC3339), expected.Format(time.RFC3339))
+	}
+
+	next, err := updatedValue(ctx, tx, it)
+	if err != nil {
+		return err
+	}
+	if !next.Truncate(time.Second).After(current) {
+		return errors.Conflictf("%s has to be updated later than %s, received %s", iri, current.Format(time.RFC3339), next.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// updatedValueQ computes the "updated" value of a raw item, the same way as the updated column of the tables.
+const updatedValueQ = "SELECT coalesce(json_extract(?1, '$.updated'), json_extract(?1, '$.deleted'), json_extract(?1, '$.published'));"
+
+// updatedValue returns the time it was last updated, which falls back to the time it was deleted or published.
+func updatedValue(ctx context.Context, tx *sql.Tx, it vocab.Item) (time.Time, error) {
+	raw, err := encodeItemFn(it)
+	if err != nil {
+		return time.Time{}, errors.Annotatef(err, "unable to marshal %s", it.GetLink())
+	}
+	var updated sql.NullString
+	if err = tx.QueryRowContext(ctx, updatedValueQ, string(raw)).Scan(&updated); err != nil {
+		return time.Time{}, wrapSQLError(err, "unable to load the updated value of %s", it.GetLink())
+	}
+	if !updated.Valid {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, updated.String)
+	if err != nil {
+		return time.Time{}, errors.Annotatef(err, "invalid updated value %q for %s", updated.String, it.GetLink())
+	}
+	return t, nil
+}
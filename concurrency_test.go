@@ -0,0 +1,128 @@
+package sqlite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+func Test_repo_SaveIfUnchanged(t *testing.T) {
+	published := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	updated := published.Add(time.Hour)
+
+	stored := &vocab.Actor{ID: "https://example.com/actors/1", Type: vocab.PersonType, Published: published, Updated: updated}
+	notUpdated := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType, Published: published}
+	noDates := &vocab.Object{ID: "https://example.com/objects/2", Type: vocab.NoteType}
+
+	tests := []struct {
+		name     string
+		setupFns []initFn
+		it       vocab.Item
+		expected time.Time
+		wantErr  bool
+	}{
+		{
+			name:     "not open",
+			it:       stored,
+			expected: updated,
+			wantErr:  true,
+		},
+		{
+			name:     "new item",
+			setupFns: []initFn{withOpenRoot, withBootstrap},
+			it:       stored,
+		},
+		{
+			name:     "new item with expected time",
+			setupFns: []initFn{withOpenRoot, withBootstrap},
+			it:       stored,
+			expected: updated,
+			wantErr:  true,
+		},
+		{
+			name:     "unchanged",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{stored})},
+			it:       &vocab.Actor{ID: stored.ID, Type: vocab.PersonType, Updated: updated.Add(time.Hour)},
+			expected: updated,
+		},
+		{
+			name:     "unchanged with sub-second precision",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{stored})},
+			it:       &vocab.Actor{ID: stored.ID, Type: vocab.PersonType, Updated: updated.Add(time.Hour)},
+			expected: updated.Add(100 * time.Millisecond),
+		},
+		{
+			name:     "changed",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{stored})},
+			it:       &vocab.Actor{ID: stored.ID, Type: vocab.PersonType, Updated: updated.Add(time.Hour)},
+			expected: published,
+			wantErr:  true,
+		},
+		{
+			name:     "not updated later",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{stored})},
+			it:       &vocab.Actor{ID: stored.ID, Type: vocab.PersonType, Updated: updated},
+			expected: updated,
+			wantErr:  true,
+		},
+		{
+			name:     "updated earlier",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{stored})},
+			it:       &vocab.Actor{ID: stored.ID, Type: vocab.PersonType, Updated: published},
+			expected: updated,
+			wantErr:  true,
+		},
+		{
+			name:     "without an updated value",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{stored})},
+			it:       &vocab.Actor{ID: stored.ID, Type: vocab.PersonType},
+			expected: updated,
+			wantErr:  true,
+		},
+		{
+			name:     "falls back to published",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{notUpdated})},
+			it:       &vocab.Object{ID: notUpdated.ID, Type: vocab.NoteType, Updated: updated},
+			expected: published,
+		},
+		{
+			name:     "stored without dates",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{noDates})},
+			it:       &vocab.Object{ID: noDates.ID, Type: vocab.NoteType, Updated: updated},
+		},
+		{
+			name:     "stored without dates, expected time",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{noDates})},
+			it:       &vocab.Object{ID: noDates.ID, Type: vocab.NoteType, Updated: updated},
+			expected: updated,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mockRepo(t, fields{path: t.TempDir()}, tt.setupFns...)
+			t.Cleanup(r.Close)
+
+			saved, err := r.SaveIfUnchanged(tt.it, tt.expected)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("SaveIfUnchanged() expected error, received nil")
+				}
+				if r.conn != nil && !errors.IsConflict(err) {
+					t.Errorf("SaveIfUnchanged() expected conflict error, received %v", err)
+				}
+				return
+			}
+			be.NilErr(t, err)
+			be.Equal(t, tt.it.GetLink(), saved.GetLink())
+
+			// NOTE(marius): the second save expecting the same time fails, as the first one changed it
+			if _, err = r.SaveIfUnchanged(tt.it, tt.expected); !errors.IsConflict(err) {
+				t.Errorf("SaveIfUnchanged() with stale time expected conflict error, received %v", err)
+			}
+		})
+	}
+}
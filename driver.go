@@ -0,0 +1,102 @@
+package sqlite
+
+import (
+	"database/sql"
+	"slices"
+
+	"github.com/go-ap/errors"
+)
+
+// The names of the SQLite drivers that can be selected with Config.Driver.
+const (
+	// DriverMattn is the github.com/mattn/go-sqlite3 driver, it requires CGO.
+	DriverMattn = "mattn"
+	// DriverModernc is the modernc.org/sqlite driver, which is a transpilation of SQLite to Go.
+	DriverModernc = "modernc"
+	// DriverNcruces is the github.com/ncruces/go-sqlite3 driver, which runs SQLite compiled to WASM.
+	// It is available only when building with the "ncruces" tag.
+	DriverNcruces = "ncruces"
+)
+
+// sqlDriver is the glue between the package and one of the SQLite drivers.
+type sqlDriver struct {
+	// open returns a connection pool for the database file at path.
+	open func(path string, o ConnectionOptions) (*sql.DB, error)
+	// errorCode returns the primary and extended SQLite result codes of err, when err, or one
+	// of the errors it wraps, was returned by the driver.
+	errorCode func(err error) (code, extended int, ok bool)
+}
+
+var drivers = make(map[string]sqlDriver)
+
+func registerDriver(name string, d sqlDriver) {
+	drivers[name] = d
+}
+
+// Drivers returns the names of the SQLite drivers available in the current build.
+func Drivers() []string {
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func getDriver(name string) (sqlDriver, error) {
+	if name == "" {
+		name = defaultDriver
+	}
+	d, ok := drivers[name]
+	if !ok {
+		return sqlDriver{}, errors.NotImplementedf("sqlite driver %q is not available, valid values are %v", name, Drivers())
+	}
+	return d, nil
+}
+
+// sqlOpen returns a connection pool for the database file at path, using the driver with name, or the default one
+// for the current build when name is empty.
+func sqlOpen(name, path string, o ConnectionOptions) (*sql.DB, error) {
+	d, err := getDriver(name)
+	if err != nil {
+		return nil, err
+	}
+	return d.open(path, o)
+}
+
+// errorCode returns the SQLite result codes of err, independently of the driver that returned it.
+func errorCode(err error) (code, extended int, ok bool) {
+	if err == nil {
+		return 0, 0, false
+	}
+	for _, d := range drivers {
+		if code, extended, ok = d.errorCode(err); ok {
+			return code, extended, ok
+		}
+	}
+	return 0, 0, false
+}
+
+// sqliteError is a driver independent SQLite error.
+// It matches, using errors.Is, any of the errors returned by the drivers that have the same result code.
+type sqliteError struct {
+	code int
+	msg  string
+}
+
+func (e sqliteError) Error() string {
+	return e.msg
+}
+
+func (e sqliteError) Is(target error) bool {
+	if t, ok := target.(sqliteError); ok {
+		return t.code == e.code
+	}
+	code, _, ok := errorCode(target)
+	return ok && code == e.code
+}
+
+var (
+	errCantOpen    = sqliteError{code: 14, msg: "unable to open database file"}
+	errNoSuchTable = sqliteError{code: 1, msg: "SQL logic error"}
+)
@@ -0,0 +1,79 @@
+//go:build cgo
+
+package sqlite
+
+import (
+	"database/sql"
+	"net/url"
+
+	"github.com/go-ap/errors"
+	"github.com/mattn/go-sqlite3"
+)
+
+const defaultDriver = DriverMattn
+
+func init() {
+	registerDriver(DriverMattn, sqlDriver{open: mattnOpen, errorCode: mattnErrorCode})
+}
+
+func mattnErrorCode(err error) (int, int, bool) {
+	var e sqlite3.Error
+	if !errors.As(err, &e) {
+		return 0, 0, false
+	}
+	return int(e.Code), int(e.ExtendedCode), true
+}
+
+var mattnQueryParam = url.Values{
+	"_txlock":       []string{"immediate"},
+	"_journal_mode": []string{"WAL"},
+}
+
+// mattnPragmaParams are the parameters of the data source name that the driver uses to set the pragmas
+// on every connection. The driver doesn't support setting any other pragmas.
+var mattnPragmaParams = map[string]string{
+	"auto_vacuum":              "_auto_vacuum",
+	"busy_timeout":             "_busy_timeout",
+	"cache_size":               "_cache_size",
+	"case_sensitive_like":      "_case_sensitive_like",
+	"defer_foreign_keys":       "_defer_foreign_keys",
+	"foreign_keys":             "_foreign_keys",
+	"ignore_check_constraints": "_ignore_check_constraints",
+	"journal_mode":             "_journal_mode",
+	"locking_mode":             "_locking_mode",
+	"query_only":               "_query_only",
+	"recursive_triggers":       "_recursive_triggers",
+	"secure_delete":            "_secure_delete",
+	"synchronous":              "_synchronous",
+	"writable_schema":          "_writable_schema",
+}
+
+// mattnOpen uses the github.com/mattn/go-sqlite3 package which is available only when compiled with CGO
+// this driver is more performant but, as said, it requires CGO
+// NOTE(marius): the full text search index requires the package to be built with the "sqlite_fts5" tag,
+// without it the search index is not created, and Search returns an error.
+func mattnOpen(dataSourceName string, o ConnectionOptions) (*sql.DB, error) {
+	pragmas, err := o.pragmas()
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{"_txlock": mattnQueryParam["_txlock"]}
+	if o.readOnly {
+		q.Set("mode", "ro")
+	} else {
+		q.Set("_journal_mode", mattnQueryParam.Get("_journal_mode"))
+	}
+	// NOTE(marius): the pragmas from the options are set last, so they can override the defaults
+	for _, p := range pragmas {
+		param, ok := mattnPragmaParams[p.name]
+		if !ok {
+			return nil, errors.NotImplementedf("pragma %s is not supported by the %s driver", p.name, DriverMattn)
+		}
+		q.Set(param, p.value)
+	}
+	if o.readOnly {
+		// NOTE(marius): the mode parameter is supported only for URI file names
+		return sql.Open("sqlite3", "file:"+dataSourceName+"?"+q.Encode())
+	}
+	return sql.Open("sqlite3", dataSourceName+"?"+q.Encode())
+}
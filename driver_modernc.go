@@ -0,0 +1,61 @@
+package sqlite
+
+import (
+	"database/sql"
+	"net/url"
+
+	"github.com/go-ap/errors"
+	"modernc.org/sqlite"
+)
+
+func init() {
+	registerDriver(DriverModernc, sqlDriver{open: moderncOpen, errorCode: moderncErrorCode})
+}
+
+var moderncQueryParam = url.Values{
+	"_txlock": []string{"immediate"},
+	"_pragma": []string{
+		"journal_mode=WAL",
+		"wal_autocheckpoint=0",
+		"strict=1",
+	},
+}
+
+func moderncErrorCode(err error) (int, int, bool) {
+	var e *sqlite.Error
+	if !errors.As(err, &e) {
+		return 0, 0, false
+	}
+	return e.Code() & 0xff, e.Code(), true
+}
+
+// moderncOpen uses the modernc.org/sqlite package, which doesn't require CGO
+// this driver is less performant.
+func moderncOpen(dataSourceName string, o ConnectionOptions) (*sql.DB, error) {
+	pragmas, err := o.pragmas()
+	if err != nil {
+		return nil, err
+	}
+	// NOTE(marius): the busy timeout goes first, so it applies to the default pragmas, and the other pragmas
+	// from the options go last, so they can override the defaults.
+	q := url.Values{"_txlock": moderncQueryParam["_txlock"]}
+	for _, p := range pragmas {
+		if p.name == "busy_timeout" {
+			q.Add("_pragma", p.name+"="+p.value)
+		}
+	}
+	if !o.readOnly {
+		q["_pragma"] = append(q["_pragma"], moderncQueryParam["_pragma"]...)
+	}
+	for _, p := range pragmas {
+		if p.name != "busy_timeout" {
+			q.Add("_pragma", p.name+"="+p.value)
+		}
+	}
+	if o.readOnly {
+		// NOTE(marius): the mode parameter is supported only for URI file names
+		q.Set("mode", "ro")
+		return sql.Open("sqlite", "file:"+dataSourceName+"?"+q.Encode())
+	}
+	return sql.Open("sqlite", dataSourceName+"?"+q.Encode())
+}
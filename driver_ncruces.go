@@ -0,0 +1,59 @@
+//go:build ncruces
+
+package sqlite
+
+import (
+	"database/sql"
+	"net/url"
+
+	"github.com/go-ap/errors"
+	"github.com/ncruces/go-sqlite3"
+	ncruces "github.com/ncruces/go-sqlite3/driver"
+	_ "github.com/ncruces/go-sqlite3/embed"
+)
+
+func init() {
+	registerDriver(DriverNcruces, sqlDriver{open: ncrucesOpen, errorCode: ncrucesErrorCode})
+}
+
+var ncrucesPragmas = []string{
+	"journal_mode(WAL)",
+	"wal_autocheckpoint(0)",
+}
+
+func ncrucesErrorCode(err error) (int, int, bool) {
+	var e *sqlite3.Error
+	if !errors.As(err, &e) {
+		return 0, 0, false
+	}
+	return int(e.Code()), int(e.ExtendedCode()), true
+}
+
+// ncrucesOpen uses the github.com/ncruces/go-sqlite3 package, which runs SQLite compiled to WASM and doesn't require CGO.
+func ncrucesOpen(dataSourceName string, o ConnectionOptions) (*sql.DB, error) {
+	pragmas, err := o.pragmas()
+	if err != nil {
+		return nil, err
+	}
+	// NOTE(marius): the busy timeout goes first, so it applies to the default pragmas, and the other pragmas
+	// from the options go last, so they can override the defaults.
+	q := url.Values{"_txlock": []string{"immediate"}}
+	for _, p := range pragmas {
+		if p.name == "busy_timeout" {
+			q.Add("_pragma", p.name+"("+p.value+")")
+		}
+	}
+	if o.readOnly {
+		q.Set("mode", "ro")
+	} else {
+		q["_pragma"] = append(q["_pragma"], ncrucesPragmas...)
+	}
+	for _, p := range pragmas {
+		if p.name != "busy_timeout" {
+			q.Add("_pragma", p.name+"("+p.value+")")
+		}
+	}
+	// NOTE(marius): the driver accepts parameters only for URI file names
+	u := url.URL{Scheme: "file", OmitHost: true, Path: dataSourceName, RawQuery: q.Encode()}
+	return ncruces.Open(u.String())
+}
@@ -0,0 +1,106 @@
+package sqlite
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_getDriver(t *testing.T) {
+	tests := []struct {
+		name    string
+		arg     string
+		wantErr error
+	}{
+		{
+			name: "default",
+			arg:  "",
+		},
+		{
+			name: "modernc is always available",
+			arg:  DriverModernc,
+		},
+		{
+			name:    "unknown",
+			arg:     "test",
+			wantErr: errors.NotImplementedf("sqlite driver %q is not available, valid values are %v", "test", Drivers()),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := getDriver(tt.arg)
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Errorf("getDriver() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+				return
+			}
+			if tt.wantErr == nil && (d.open == nil || d.errorCode == nil) {
+				t.Errorf("getDriver() returned incomplete driver %q", tt.arg)
+			}
+		})
+	}
+}
+
+func TestNew_invalidDriver(t *testing.T) {
+	_, err := New(Config{Path: t.TempDir(), Driver: "test"})
+	if !errors.IsNotImplemented(err) {
+		t.Errorf("New() with invalid driver expected not implemented error, received %v", err)
+	}
+}
+
+func TestDrivers(t *testing.T) {
+	ob := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType}
+
+	if !slices.Contains(Drivers(), defaultDriver) {
+		t.Fatalf("Drivers() %v doesn't contain the default driver %s", Drivers(), defaultDriver)
+	}
+	for _, name := range Drivers() {
+		t.Run(name, func(t *testing.T) {
+			conf := Config{Path: t.TempDir(), Driver: name, LogFn: t.Logf, ErrFn: t.Errorf}
+			be.NilErr(t, Bootstrap(conf))
+
+			r, err := New(conf)
+			be.NilErr(t, err)
+			be.NilErr(t, r.Open())
+			t.Cleanup(r.Close)
+
+			_, err = r.Save(ob)
+			be.NilErr(t, err)
+			it, err := r.Load(ob.ID)
+			be.NilErr(t, err)
+			be.Equal(t, ob.ID, it.GetLink())
+
+			// NOTE(marius): the driver errors need to be matched by the driver independent ones
+			_, err = r.ro.ExecContext(context.Background(), "SELECT * FROM missing_table;")
+			if !errNoSuchTable.Is(err) {
+				t.Errorf("%s error %v doesn't match %v", name, err, errNoSuchTable)
+			}
+			code, _, ok := errorCode(err)
+			be.True(t, ok)
+			be.Equal(t, 1, code)
+		})
+	}
+}
+
+func Test_sqliteError_Is(t *testing.T) {
+	forbiddenPath := createForbiddenDir(t)
+	for _, name := range Drivers() {
+		t.Run(name, func(t *testing.T) {
+			db, err := sqlOpen(name, forbiddenPath+"/"+dbFile, ConnectionOptions{})
+			be.NilErr(t, err)
+			t.Cleanup(func() { _ = db.Close() })
+
+			err = db.Ping()
+			if !errCantOpen.Is(err) {
+				t.Errorf("%s error %v doesn't match %v", name, err, errCantOpen)
+			}
+			if errNoSuchTable.Is(err) {
+				t.Errorf("%s error %v matches %v", name, err, errNoSuchTable)
+			}
+		})
+	}
+}
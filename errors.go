@@ -0,0 +1,47 @@
+package sqlite
+
+import (
+	"github.com/go-ap/errors"
+)
+
+// The SQLite primary result codes that we translate to go-ap/errors types.
+// See https://www.sqlite.org/rescode.html
+const (
+	sqlitePerm       = 3
+	sqliteBusy       = 5
+	sqliteLocked     = 6
+	sqliteReadOnly   = 8
+	sqliteCantOpen   = 14
+	sqliteConstraint = 19
+	sqliteAuth       = 23
+)
+
+// wrapSQLError annotates err, returned by the database driver, using the go-ap/errors type that matches
+// its SQLite result code, so the callers can tell apart the causes of the failure:
+//   - SQLITE_BUSY and SQLITE_LOCKED become service unavailable errors, which can be retried, see IsRetryable.
+//   - SQLITE_CONSTRAINT becomes a conflict error.
+//   - SQLITE_READONLY, SQLITE_CANTOPEN, SQLITE_PERM and SQLITE_AUTH become forbidden errors.
+//
+// All other errors are annotated with their message unchanged.
+func wrapSQLError(err error, format string, args ...any) error {
+	code, _, ok := errorCode(err)
+	if !ok {
+		return errors.Annotatef(err, format, args...)
+	}
+	switch code {
+	case sqliteBusy, sqliteLocked:
+		return errors.NewServiceUnavailable(err, format, args...)
+	case sqliteConstraint:
+		return errors.NewConflict(err, format, args...)
+	case sqliteReadOnly, sqliteCantOpen, sqlitePerm, sqliteAuth:
+		return errors.NewForbidden(err, format, args...)
+	}
+	return errors.Annotatef(err, format, args...)
+}
+
+// IsRetryable returns true if err was caused by the database being locked by another connection,
+// which means that the operation can succeed if it's retried.
+func IsRetryable(err error) bool {
+	code, _, ok := errorCode(err)
+	return ok && (code == sqliteBusy || code == sqliteLocked)
+}
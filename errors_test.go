@@ -0,0 +1,101 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+	"github.com/go-ap/errors"
+)
+
+func Test_wrapSQLError(t *testing.T) {
+	ctx := context.Background()
+	forbiddenPath := createForbiddenDir(t)
+
+	tests := []struct {
+		name      string
+		setupFns  []initFn
+		errFn     func(*testing.T, *repo) error
+		isFn      func(error) bool
+		retryable bool
+	}{
+		{
+			name: "not a sqlite error",
+			errFn: func(_ *testing.T, _ *repo) error {
+				return errors.Newf("test")
+			},
+			isFn: func(err error) bool {
+				return err != nil && !errors.IsServiceUnavailable(err) && !errors.IsConflict(err) && !errors.IsForbidden(err)
+			},
+		},
+		{
+			name:     "no such table",
+			setupFns: []initFn{withOpenRoot, withBootstrap},
+			errFn: func(_ *testing.T, r *repo) error {
+				_, err := r.conn.ExecContext(ctx, "SELECT * FROM missing_table;")
+				return err
+			},
+			isFn: func(err error) bool {
+				return err != nil && !errors.IsServiceUnavailable(err) && !errors.IsConflict(err) && !errors.IsForbidden(err)
+			},
+		},
+		{
+			name:     "constraint is conflict",
+			setupFns: []initFn{withOpenRoot, withBootstrap},
+			errFn: func(_ *testing.T, r *repo) error {
+				_, err := r.conn.ExecContext(ctx, "INSERT INTO clients (code, secret, redirect_uri) VALUES (?, '', ''), (?, '', '');", "test", "test")
+				return err
+			},
+			isFn: errors.IsConflict,
+		},
+		{
+			name: "can't open is forbidden",
+			errFn: func(t *testing.T, _ *repo) error {
+				db, err := sqlOpen(defaultDriver, forbiddenPath+"/"+dbFile, ConnectionOptions{})
+				be.NilErr(t, err)
+				t.Cleanup(func() { _ = db.Close() })
+				return db.Ping()
+			},
+			isFn: errors.IsForbidden,
+		},
+		{
+			name:     "busy is service unavailable",
+			setupFns: []initFn{withOpenRoot, withBootstrap},
+			errFn: func(t *testing.T, r *repo) error {
+				tx, err := r.conn.BeginTx(ctx, nil)
+				be.NilErr(t, err)
+				t.Cleanup(func() { _ = tx.Rollback() })
+				_, err = tx.ExecContext(ctx, "INSERT INTO clients (code, secret, redirect_uri) VALUES (?, '', '');", "test")
+				be.NilErr(t, err)
+
+				db, err := sqlOpen(defaultDriver, r.path, ConnectionOptions{BusyTimeout: time.Millisecond})
+				be.NilErr(t, err)
+				t.Cleanup(func() { _ = db.Close() })
+				_, err = db.ExecContext(ctx, "INSERT INTO clients (code, secret, redirect_uri) VALUES (?, '', '');", "test2")
+				return err
+			},
+			isFn:      errors.IsServiceUnavailable,
+			retryable: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mockRepo(t, fields{path: t.TempDir()}, tt.setupFns...)
+			t.Cleanup(r.Close)
+
+			err := tt.errFn(t, r)
+			be.True(t, err != nil)
+
+			wrapped := wrapSQLError(err, "test")
+			if !tt.isFn(wrapped) {
+				t.Errorf("wrapSQLError() returned unexpected error type %T: %s", wrapped, wrapped)
+			}
+			if !errors.Is(wrapped, err) {
+				t.Errorf("wrapSQLError() returned error that doesn't wrap %s", err)
+			}
+			be.Equal(t, tt.retryable, IsRetryable(err))
+			be.Equal(t, tt.retryable, IsRetryable(wrapped))
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package sqlite
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// ExportOptions configures Export.
+type ExportOptions struct {
+	// Prefix limits the export to the items, and metadata, with IRIs that start with it,
+	// and to the OAuth2 clients with user data, which is the IRI of their actor, that starts with it.
+	Prefix vocab.IRI
+	// Metadata includes the metadata of the items, which holds the password hashes and the private keys.
+	Metadata bool
+	// Clients includes the OAuth2 clients.
+	Clients bool
+}
+
+// exportTables are the tables that hold the items, in the order in which they get exported.
+var exportTables = []string{"actors", "objects", "activities", "collections"}
+
+// exportedMetadata is the line written by Export for the metadata of an item.
+type exportedMetadata struct {
+	Metadata struct {
+		IRI   vocab.IRI       `json:"iri"`
+		Value json.RawMessage `json:"value"`
+	} `json:"metadata"`
+}
+
+// exportedClient is the line written by Export for an OAuth2 client.
+type exportedClient struct {
+	Client struct {
+		ID          string `json:"id"`
+		Secret      string `json:"secret"`
+		RedirectURI string `json:"redirectUri"`
+		Extra       string `json:"extra,omitempty"`
+	} `json:"client"`
+}
+
+// Export writes the actors, objects, activities and collections to w as newline delimited JSON-LD, one item per line.
+// The collections hold the IRIs of their items, in the order in which they were added.
+//
+// When requested, they are followed by lines for the metadata, of the form {"metadata":{"iri":"...","value":{...}}},
+// and for the OAuth2 clients, of the form {"client":{"id":"...","secret":"...","redirectUri":"...","extra":"..."}}.
+//
+// Everything is read in a single read transaction, so the export is consistent even if the database
+// is changed while it's running, and the writers are not blocked by it.
+func (r *repo) Export(ctx context.Context, w io.Writer, opts ExportOptions) error {
+	if r == nil || r.ro == nil {
+		return errNotOpen
+	}
+
+	// NOTE(marius): the connections are opened with "_txlock=immediate", so a transaction started with BeginTx
+	// would hold the write lock for the whole export. We start a deferred transaction on a dedicated connection
+	// instead, which only takes a read snapshot and doesn't block the writers.
+	conn, err := r.ro.Conn(ctx)
+	if err != nil {
+		return wrapSQLError(err, "unable to get connection")
+	}
+	if _, err = conn.ExecContext(ctx, "BEGIN DEFERRED;"); err != nil {
+		_ = conn.Close()
+		return wrapSQLError(err, "transaction start error")
+	}
+
+	err = exportAll(ctx, conn, w, opts)
+	if _, rErr := conn.ExecContext(context.WithoutCancel(ctx), "ROLLBACK;"); rErr != nil {
+		// NOTE(marius): the transaction could still be open on the connection, so it must not go back to the pool
+		_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+		if err == nil {
+			err = wrapSQLError(rErr, "transaction rollback error")
+		}
+	}
+	if cErr := conn.Close(); cErr != nil && err == nil {
+		err = wrapSQLError(cErr, "unable to close connection")
+	}
+	return err
+}
+
+// exportAll writes the items matching opts, read using conn, to w.
+func exportAll(ctx context.Context, conn *sql.Conn, w io.Writer, opts ExportOptions) error {
+	pattern := likeEscape(opts.Prefix.String()) + "%"
+	out := bufio.NewWriter(w)
+	for _, table := range exportTables {
+		if err := exportItems(ctx, conn, out, table, pattern); err != nil {
+			return err
+		}
+	}
+	if opts.Metadata {
+		if err := exportMetadata(ctx, conn, out, pattern); err != nil {
+			return err
+		}
+	}
+	if opts.Clients {
+		if err := exportClients(ctx, conn, out, pattern); err != nil {
+			return err
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return errors.Annotatef(err, "unable to write export")
+	}
+	return nil
+}
+
+func writeLine(w *bufio.Writer, data []byte) error {
+	if _, err := w.Write(data); err != nil {
+		return errors.Annotatef(err, "unable to write export")
+	}
+	if err := w.WriteByte('\n'); err != nil {
+		return errors.Annotatef(err, "unable to write export")
+	}
+	return nil
+}
+
+const (
+	exportItemsQ = "SELECT raw, NULL FROM %s WHERE iri LIKE ? ESCAPE '\\' ORDER BY iri;"
+	// NOTE(marius): the collections without items get a [null] array
+	exportCollectionsQ = `SELECT c.raw, json_group_array(ci.item_iri ORDER BY ci.position) FROM collections c
+	LEFT JOIN collection_items ci ON ci.collection_iri = c.iri
+	WHERE c.iri LIKE ? ESCAPE '\' GROUP BY c.iri ORDER BY c.iri;`
+)
+
+func exportItems(ctx context.Context, tx querier, w *bufio.Writer, table, pattern string) error {
+	query := exportCollectionsQ
+	if table != "collections" {
+		query = fmt.Sprintf(exportItemsQ, table)
+	}
+	rows, err := tx.QueryContext(ctx, query, pattern)
+	if err != nil {
+		return wrapSQLError(err, "unable to export %s", table)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var raw, members []byte
+		if err = rows.Scan(&raw, &members); err != nil {
+			return wrapSQLError(err, "scan values error")
+		}
+		if len(raw) == 0 {
+			continue
+		}
+		it, err := vocab.UnmarshalJSON(raw)
+		if err != nil {
+			return errors.Annotatef(err, "unable to unmarshal item from %s", table)
+		}
+		if len(members) > 0 {
+			if err = setCollectionMembers(it, members); err != nil {
+				return err
+			}
+		}
+		data, err := encodeItemFn(it)
+		if err != nil {
+			return errors.Annotatef(err, "unable to marshal %s", it.GetLink())
+		}
+		if err = writeLine(w, data); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return wrapSQLError(err, "unable to export %s", table)
+	}
+	return nil
+}
+
+// setCollectionMembers sets the items of the collection it, to the IRIs from the members JSON array.
+func setCollectionMembers(it vocab.Item, members []byte) error {
+	// NOTE(marius): the items of the storage collections are the rows of their tables
+	if isStorageCollectionIRI(it.GetLink()) {
+		return nil
+	}
+	var iris []*string
+	if err := json.Unmarshal(members, &iris); err != nil {
+		return errors.Annotatef(err, "unable to unmarshal items of %s", it.GetLink())
+	}
+	items := make(vocab.ItemCollection, 0, len(iris))
+	for _, iri := range iris {
+		if iri != nil {
+			items = append(items, vocab.IRI(*iri))
+		}
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	typ := it.GetType()
+	if orderedCollectionTypes.Match(typ) {
+		return vocab.OnOrderedCollection(it, func(col *vocab.OrderedCollection) error {
+			col.OrderedItems = items
+			return nil
+		})
+	}
+	if collectionTypes.Match(typ) {
+		return vocab.OnCollection(it, func(col *vocab.Collection) error {
+			col.Items = items
+			return nil
+		})
+	}
+	return nil
+}
+
+func exportMetadata(ctx context.Context, tx querier, w *bufio.Writer, pattern string) error {
+	rows, err := tx.QueryContext(ctx, "SELECT iri, raw FROM meta WHERE iri LIKE ? ESCAPE '\\' ORDER BY iri;", pattern)
+	if err != nil {
+		return wrapSQLError(err, "unable to export metadata")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m := exportedMetadata{}
+		var raw []byte
+		if err = rows.Scan(&m.Metadata.IRI, &raw); err != nil {
+			return wrapSQLError(err, "scan values error")
+		}
+		if len(raw) > 0 {
+			m.Metadata.Value = raw
+		}
+		data, err := json.Marshal(m)
+		if err != nil {
+			return errors.Annotatef(err, "unable to marshal metadata for %s", m.Metadata.IRI)
+		}
+		if err = writeLine(w, data); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return wrapSQLError(err, "unable to export metadata")
+	}
+	return nil
+}
+
+func exportClients(ctx context.Context, tx querier, w *bufio.Writer, pattern string) error {
+	// NOTE(marius): the clients without user data are exported only when there's no prefix
+	sel := "SELECT code, secret, redirect_uri, extra FROM clients WHERE ? = '%' OR extra LIKE ? ESCAPE '\\' ORDER BY code;"
+	rows, err := tx.QueryContext(ctx, sel, pattern, pattern)
+	if err != nil {
+		return wrapSQLError(err, "unable to export clients")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c := exportedClient{}
+		var extra sql.NullString
+		if err = rows.Scan(&c.Client.ID, &c.Client.Secret, &c.Client.RedirectURI, &extra); err != nil {
+			return wrapSQLError(err, "scan values error")
+		}
+		c.Client.Extra = extra.String
+		data, err := json.Marshal(c)
+		if err != nil {
+			return errors.Annotatef(err, "unable to marshal client %s", c.Client.ID)
+		}
+		if err = writeLine(w, data); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return wrapSQLError(err, "unable to export clients")
+	}
+	return nil
+}
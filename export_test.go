@@ -0,0 +1,174 @@
+package sqlite
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/google/go-cmp/cmp"
+	"github.com/openshift/osin"
+)
+
+// exportedLines holds the IRIs of the items, metadata and clients found in an export, by kind.
+type exportedLines struct {
+	items    vocab.IRIs
+	metadata vocab.IRIs
+	clients  []string
+	raw      map[vocab.IRI]map[string]any
+}
+
+func parseExport(t *testing.T, data []byte) exportedLines {
+	lines := exportedLines{raw: make(map[vocab.IRI]map[string]any)}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		m := make(map[string]any)
+		if err := json.Unmarshal(s.Bytes(), &m); err != nil {
+			t.Fatalf("invalid export line %q: %s", s.Text(), err)
+		}
+		switch {
+		case m["metadata"] != nil:
+			meta := m["metadata"].(map[string]any)
+			lines.metadata = append(lines.metadata, vocab.IRI(meta["iri"].(string)))
+		case m["client"] != nil:
+			c := m["client"].(map[string]any)
+			lines.clients = append(lines.clients, c["id"].(string))
+		default:
+			iri := vocab.IRI(m["id"].(string))
+			lines.items = append(lines.items, iri)
+			lines.raw[iri] = m
+		}
+	}
+	be.NilErr(t, s.Err())
+	return lines
+}
+
+func Test_repo_Export(t *testing.T) {
+	actor1 := &vocab.Actor{ID: "https://example.com/actors/1", Type: vocab.PersonType}
+	outbox1 := &vocab.OrderedCollection{ID: "https://example.com/actors/1/outbox", Type: vocab.OrderedCollectionType}
+	note1 := &vocab.Object{ID: "https://example.com/actors/1/notes/1", Type: vocab.NoteType}
+	actor2 := &vocab.Actor{ID: "https://example.com/actors/2", Type: vocab.PersonType}
+	note2 := &vocab.Object{ID: "https://example.com/actors/2/notes/1", Type: vocab.NoteType}
+
+	withExportItems := func(t *testing.T, r *repo) *repo {
+		withGeneratedItems(vocab.ItemCollection{actor1, outbox1, note1, actor2, note2})(t, r)
+		be.NilErr(t, r.AddTo(outbox1.ID, note1))
+		be.NilErr(t, r.SaveMetadata(actor1.ID, &Metadata{Pw: []byte("test")}))
+		be.NilErr(t, r.SaveMetadata(actor2.ID, &Metadata{Pw: []byte("test")}))
+		be.NilErr(t, r.SaveClient(&osin.DefaultClient{Id: "client1", Secret: "test", RedirectUri: "/", UserData: actor1.ID.String()}))
+		be.NilErr(t, r.SaveClient(&osin.DefaultClient{Id: "client2", Secret: "test", RedirectUri: "/", UserData: actor2.ID.String()}))
+		return r
+	}
+
+	tests := []struct {
+		name         string
+		setupFns     []initFn
+		opts         ExportOptions
+		wantItems    vocab.IRIs
+		wantMetadata vocab.IRIs
+		wantClients  []string
+		wantErr      error
+	}{
+		{
+			name:    "not open",
+			wantErr: errNotOpen,
+		},
+		{
+			name:      "everything",
+			setupFns:  []initFn{withOpenRoot, withBootstrap, withExportItems},
+			wantItems: vocab.IRIs{actor1.ID, outbox1.ID, note1.ID, actor2.ID, note2.ID},
+		},
+		{
+			name:         "everything, with metadata and clients",
+			setupFns:     []initFn{withOpenRoot, withBootstrap, withExportItems},
+			opts:         ExportOptions{Metadata: true, Clients: true},
+			wantItems:    vocab.IRIs{actor1.ID, outbox1.ID, note1.ID, actor2.ID, note2.ID},
+			wantMetadata: vocab.IRIs{actor1.ID, actor2.ID},
+			wantClients:  []string{"client1", "client2"},
+		},
+		{
+			name:         "one actor",
+			setupFns:     []initFn{withOpenRoot, withBootstrap, withExportItems},
+			opts:         ExportOptions{Prefix: actor1.ID, Metadata: true, Clients: true},
+			wantItems:    vocab.IRIs{actor1.ID, outbox1.ID, note1.ID},
+			wantMetadata: vocab.IRIs{actor1.ID},
+			wantClients:  []string{"client1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mockRepo(t, fields{path: t.TempDir()}, tt.setupFns...)
+			t.Cleanup(r.Close)
+
+			buf := bytes.Buffer{}
+			err := r.Export(context.Background(), &buf, tt.opts)
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Fatalf("Export() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			got := parseExport(t, buf.Bytes())
+			for _, iri := range tt.wantItems {
+				if !slices.Contains(got.items, iri) {
+					t.Errorf("Export() item %s was not exported", iri)
+				}
+			}
+			if tt.opts.Prefix != "" {
+				be.Equal(t, len(tt.wantItems), len(got.items))
+			}
+			be.AllEqual(t, tt.wantMetadata, got.metadata)
+			be.AllEqual(t, tt.wantClients, got.clients)
+
+			// NOTE(marius): the collections are exported with the IRIs of their items
+			if ob, ok := got.raw[outbox1.ID]; ok {
+				be.AllEqual(t, []any{note1.ID.String()}, ob["orderedItems"].([]any))
+			}
+		})
+	}
+}
+
+// writerFn is an io.Writer that calls a function on every write.
+type writerFn func(p []byte) (int, error)
+
+func (w writerFn) Write(p []byte) (int, error) {
+	return w(p)
+}
+
+func Test_repo_Export_doesNotBlockWriters(t *testing.T) {
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap)
+	t.Cleanup(r.Close)
+
+	note := &vocab.Object{ID: "https://example.com/objects/written-during-export", Type: vocab.NoteType}
+
+	buf := bytes.Buffer{}
+	var saveErr error
+	saved := false
+	w := writerFn(func(p []byte) (int, error) {
+		// NOTE(marius): the export's read transaction is still open while its output gets written
+		if !saved {
+			saved = true
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			_, saveErr = r.SaveContext(ctx, note)
+		}
+		return buf.Write(p)
+	})
+
+	be.NilErr(t, r.Export(context.Background(), w, ExportOptions{}))
+	be.True(t, saved)
+	be.NilErr(t, saveErr)
+
+	// the export holds the snapshot from before the write
+	got := parseExport(t, buf.Bytes())
+	be.False(t, slices.Contains(got.items, note.ID))
+
+	_, err := r.Load(note.ID)
+	be.NilErr(t, err)
+}
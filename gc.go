@@ -0,0 +1,138 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+const defaultGCBatchSize = 100
+
+// GCOptions configures CollectGarbage.
+type GCOptions struct {
+	// Before is the threshold for the age of the items: only the items last updated, or published, before it are removed.
+	Before time.Time
+	// BatchSize is the number of items removed in each transaction, it defaults to 100.
+	BatchSize int
+	// Progress, when set, is called after each batch with the IRIs that were removed in it,
+	// and the total number of items removed so far.
+	Progress func(removed vocab.IRIs, total int64)
+}
+
+// gcTables are the tables that hold the items fetched from remote instances. The objects come first
+// as removing them can leave their authors unreferenced.
+var gcTables = []string{"objects", "actors"}
+
+// unreferencedQ selects the items from a table that are not part of any collection, are not the actor or
+// the object of any activity, and are not the author of, or the object replied to by any stored object.
+//
+// NOTE(marius): only the references that are a single IRI are matched, the ones found in arrays are ignored.
+// The dates are compared using datetime, as the stored values can have different formats and time zones.
+const unreferencedQ = `SELECT iri FROM %s WHERE datetime(updated) < datetime(?) AND NOT %s
+	AND iri NOT IN (SELECT item_iri FROM collection_items)
+	AND iri NOT IN (SELECT actor FROM activities WHERE actor IS NOT NULL)
+	AND iri NOT IN (SELECT object FROM activities WHERE object IS NOT NULL)
+	AND iri NOT IN (SELECT json_extract(raw, '$.attributedTo') FROM objects WHERE json_type(raw, '$.attributedTo') = 'text')
+	AND iri NOT IN (SELECT json_extract(raw, '$.inReplyTo') FROM objects WHERE json_type(raw, '$.inReplyTo') = 'text')
+	ORDER BY iri LIMIT ?;`
+
+// CollectGarbage removes the objects and actors fetched from remote instances that are no longer referenced
+// by any collection, activity or object, and were last updated before opts.Before.
+// The items are removed in batches, each in its own transaction, until no unreferenced items are left.
+// It returns the number of items removed.
+//
+// The items with IRIs under the Config.LocalBaseIRIs are never removed, and it refuses to run when those
+// are not configured. The items without any dates are kept.
+func (r *repo) CollectGarbage(ctx context.Context, opts GCOptions) (int64, error) {
+	if r == nil || r.conn == nil {
+		return 0, errNotOpen
+	}
+	if len(r.localBaseIRIs) == 0 {
+		return 0, errors.Newf("unable to collect garbage without the local base IRIs")
+	}
+	if opts.Before.IsZero() {
+		return 0, errors.Newf("unable to collect garbage without an age threshold")
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultGCBatchSize
+	}
+
+	localCond, localArgs := localIRIsCond("iri", r.localBaseIRIs)
+	args := append([]any{opts.Before.UTC().Format(time.RFC3339)}, localArgs...)
+	args = append(args, opts.BatchSize)
+
+	var total int64
+	for _, table := range gcTables {
+		query := fmt.Sprintf(unreferencedQ, table, localCond)
+		_, err := r.deleteInBatches(ctx, query, args, func(removed vocab.IRIs) {
+			total += int64(len(removed))
+			if opts.Progress != nil {
+				opts.Progress(removed, total)
+			}
+		})
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// localIRIsCond returns the SQL condition that matches the values of column that are under one of the bases,
+// and its arguments.
+func localIRIsCond(column string, bases vocab.IRIs) (string, []any) {
+	conds := make([]string, 0, len(bases))
+	args := make([]any, 0, len(bases))
+	for _, base := range bases {
+		conds = append(conds, column+" LIKE ? ESCAPE '\\'")
+		args = append(args, likeEscape(base.String())+"%")
+	}
+	return "(" + strings.Join(conds, " OR ") + ")", args
+}
+
+// deleteInBatches removes the items selected by query, together with their revisions, each batch in its own
+// transaction, until it doesn't return any more IRIs. After each batch, progress is called with the IRIs removed.
+// It returns the number of items removed.
+func (r *repo) deleteInBatches(ctx context.Context, query string, args []any, progress func(vocab.IRIs)) (int64, error) {
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		var removed, changed vocab.IRIs
+		err := r.writeTx(ctx, func(tx *sql.Tx) error {
+			iris, err := selectIRIs(ctx, tx, query, args...)
+			if err != nil {
+				return err
+			}
+			changed = changed[:0]
+			for _, iri := range iris {
+				cols, err := r.delete(ctx, tx, iri)
+				if err != nil {
+					return err
+				}
+				changed = append(changed, cols...)
+				if _, err = tx.ExecContext(ctx, "DELETE FROM revisions WHERE iri = ?;", iri); err != nil {
+					return wrapSQLError(err, "unable to delete revisions of %s", iri)
+				}
+			}
+			removed = iris
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+		r.evict(changed...)
+		if len(removed) == 0 {
+			return total, nil
+		}
+		total += int64(len(removed))
+		if progress != nil {
+			progress(removed)
+		}
+	}
+}
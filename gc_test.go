@@ -0,0 +1,156 @@
+package sqlite
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+func withLocalBaseIRIs(iris ...vocab.IRI) initFn {
+	return func(_ *testing.T, r *repo) *repo {
+		r.localBaseIRIs = iris
+		return r
+	}
+}
+
+func Test_repo_CollectGarbage(t *testing.T) {
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	threshold := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	actor := &vocab.Actor{ID: "https://example.com/actors/1", Type: vocab.PersonType, Published: old}
+	inbox := &vocab.OrderedCollection{ID: "https://example.com/actors/1/inbox", Type: vocab.OrderedCollectionType}
+	local := &vocab.Object{ID: "https://example.com/notes/1", Type: vocab.NoteType, Published: old}
+
+	author := &vocab.Actor{ID: "https://remote.org/users/alice", Type: vocab.PersonType, Published: old}
+	orphan := &vocab.Object{ID: "https://remote.org/notes/1", Type: vocab.NoteType, Published: old, AttributedTo: author.ID}
+	recent := &vocab.Object{ID: "https://remote.org/notes/2", Type: vocab.NoteType, Published: time.Now().UTC()}
+	inCollection := &vocab.Object{ID: "https://remote.org/notes/3", Type: vocab.NoteType, Published: old}
+	liked := &vocab.Object{ID: "https://remote.org/notes/4", Type: vocab.NoteType, Published: old}
+	noDates := &vocab.Object{ID: "https://remote.org/notes/5", Type: vocab.NoteType}
+	like := &vocab.Activity{ID: "https://example.com/activities/1", Type: vocab.LikeType, Actor: actor.ID, Object: liked.ID, Published: old}
+
+	all := vocab.ItemCollection{actor, inbox, local, author, orphan, recent, inCollection, liked, noDates, like}
+	withItems := func(t *testing.T, r *repo) *repo {
+		withGeneratedItems(all)(t, r)
+		be.NilErr(t, r.AddTo(inbox.ID, inCollection))
+		return r
+	}
+
+	tests := []struct {
+		name        string
+		setupFns    []initFn
+		opts        GCOptions
+		want        int64
+		wantRemoved vocab.IRIs
+		wantErr     error
+	}{
+		{
+			name:    "not open",
+			opts:    GCOptions{Before: threshold},
+			wantErr: errNotOpen,
+		},
+		{
+			name:     "no local IRIs",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withItems},
+			opts:     GCOptions{Before: threshold},
+			wantErr:  errors.Newf("unable to collect garbage without the local base IRIs"),
+		},
+		{
+			name:     "no threshold",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withItems, withLocalBaseIRIs("https://example.com")},
+			wantErr:  errors.Newf("unable to collect garbage without an age threshold"),
+		},
+		{
+			name:        "orphaned remote items",
+			setupFns:    []initFn{withOpenRoot, withBootstrap, withItems, withLocalBaseIRIs("https://example.com")},
+			opts:        GCOptions{Before: threshold, BatchSize: 1},
+			want:        2,
+			wantRemoved: vocab.IRIs{orphan.ID, author.ID},
+		},
+		{
+			name:     "before the items",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withItems, withLocalBaseIRIs("https://example.com")},
+			opts:     GCOptions{Before: old},
+		},
+		{
+			name:     "everything is local",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withItems, withLocalBaseIRIs("https://example.com", "https://remote.org")},
+			opts:     GCOptions{Before: threshold},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mockRepo(t, fields{path: t.TempDir()}, tt.setupFns...)
+			t.Cleanup(r.Close)
+
+			removed := make(vocab.IRIs, 0)
+			progress := make([]int64, 0)
+			tt.opts.Progress = func(iris vocab.IRIs, total int64) {
+				removed = append(removed, iris...)
+				progress = append(progress, total)
+			}
+
+			got, err := r.CollectGarbage(context.Background(), tt.opts)
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Fatalf("CollectGarbage() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			be.Equal(t, tt.want, got)
+			be.Equal(t, len(tt.wantRemoved), len(removed))
+			be.Equal(t, len(tt.wantRemoved), len(progress))
+
+			for _, it := range all {
+				_, err = r.Load(it.GetLink())
+				if slices.Contains(tt.wantRemoved, it.GetLink()) {
+					if !errors.IsNotFound(err) {
+						t.Errorf("Load(%s) expected not found error for removed item, received %v", it.GetLink(), err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Errorf("Load(%s) expected item to be kept, received %v", it.GetLink(), err)
+				}
+			}
+		})
+	}
+}
+
+func Test_repo_CollectGarbage_normalizesDatesAndRemovesRevisions(t *testing.T) {
+	threshold := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	orphan := &vocab.Object{ID: "https://remote.org/notes/1", Type: vocab.NoteType, Published: old}
+	updated := &vocab.Object{ID: orphan.ID, Type: vocab.NoteType, Published: old, Name: vocab.DefaultNaturalLanguage("updated")}
+	// NOTE(marius): the date is after the threshold, but it sorts before it as a string
+	offset := vocab.IRI("https://remote.org/notes/2")
+
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap, withRevisions,
+		withGeneratedItems(vocab.ItemCollection{orphan, updated}), withLocalBaseIRIs("https://example.com"))
+	t.Cleanup(r.Close)
+
+	raw := `{"id":"` + offset.String() + `","type":"Note","published":"2023-12-31T23:00:00-02:00"}`
+	_, err := r.conn.Exec("INSERT INTO objects (raw, iri) VALUES (?, ?);", raw, offset)
+	be.NilErr(t, err)
+
+	revisions, err := r.Revisions(context.Background(), orphan.ID)
+	be.NilErr(t, err)
+	be.Equal(t, 1, len(revisions))
+
+	got, err := r.CollectGarbage(context.Background(), GCOptions{Before: threshold})
+	be.NilErr(t, err)
+	be.Equal(t, int64(1), got)
+
+	_, err = r.Load(offset)
+	be.NilErr(t, err)
+
+	revisions, err = r.Revisions(context.Background(), orphan.ID)
+	be.NilErr(t, err)
+	be.Equal(t, 0, len(revisions))
+}
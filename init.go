@@ -1,99 +1,133 @@
 package sqlite
 
 const (
-	createActorsQuery = `
-CREATE TABLE IF NOT EXISTS actors (
-  "raw" TEXT,
-  "iri" TEXT NOT NULL constraint actors_key unique,
-  "id" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.id')) VIRTUAL,
-  "type" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.type')) VIRTUAL,
-  "to" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.to')) VIRTUAL,
-  "bto" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.bto')) VIRTUAL,
-  "cc" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.cc')) VIRTUAL,
-  "bcc" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.bcc')) VIRTUAL,
-  "published" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.published')) VIRTUAL,
-  "updated" TEXT GENERATED ALWAYS AS (coalesce(json_extract(raw, '$.updated'), json_extract(raw, '$.deleted'), json_extract(raw, '$.published'))) VIRTUAL,
-  "url" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.url')) VIRTUAL,
-  "name" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.name')) VIRTUAL,
-  "preferred_username" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.preferredUsername')) VIRTUAL
+	createCollectionItemsQuery = `
+CREATE TABLE IF NOT EXISTS collection_items (
+  "collection_iri" TEXT NOT NULL,
+  "item_iri" TEXT NOT NULL,
+  "added_at" TEXT DEFAULT CURRENT_TIMESTAMP,
+  "position" INTEGER NOT NULL,
+  CONSTRAINT collection_items_key UNIQUE (collection_iri, item_iri)
 ) STRICT;
-CREATE INDEX actors_type ON actors(type);
-CREATE INDEX actors_name ON actors(name, preferred_username);
-CREATE INDEX actors_published ON actors(published);
-CREATE INDEX actors_updated ON actors(updated);
+CREATE INDEX IF NOT EXISTS collection_items_position ON collection_items(collection_iri, position);
 `
 
-	createActivitiesQuery = `
-CREATE TABLE IF NOT EXISTS activities (
-  "raw" TEXT,
-  "iri" TEXT NOT NULL constraint activities_key unique,
-  "id" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.id')) VIRTUAL ,
-  "type" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.type')) VIRTUAL,
-  "to" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.to')) VIRTUAL,
-  "bto" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.bto')) VIRTUAL,
-  "cc" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.cc')) VIRTUAL,
-  "bcc" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.bcc')) VIRTUAL,
-  "published" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.published')) VIRTUAL,
-  "updated" TEXT GENERATED ALWAYS AS (coalesce(json_extract(raw, '$.updated'), json_extract(raw, '$.deleted'), json_extract(raw, '$.published'))) VIRTUAL,
-  "url" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.url')) VIRTUAL,
-  "actor" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.actor')) VIRTUAL CONSTRAINT activities_actors_iri_fk REFERENCES actors (iri),
-  "object" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.object')) VIRTUAL CONSTRAINT activities_objects_iri_fk REFERENCES objects (iri)
-) STRICT;
-CREATE INDEX activities_type ON activities(type);
-CREATE INDEX activities_actor ON activities(actor);
-CREATE INDEX activities_object ON activities(object);
-CREATE INDEX activities_published ON activities(published);
-CREATE INDEX activities_updated ON activities(updated);
+	// migrateCollectionItemsQuery moves the IRIs from the "items" JSON array column that the collections table
+	// used to have, to the collection_items table.
+	migrateCollectionItemsQuery = `
+INSERT OR IGNORE INTO collection_items (collection_iri, item_iri, position)
+  SELECT c.iri, j.value, j.key + 1 FROM collections c, json_each(c.items) j WHERE j.value IS NOT NULL;
+ALTER TABLE collections DROP COLUMN items;
 `
 
-	createObjectsQuery = `
-CREATE TABLE IF NOT EXISTS objects (
-  "raw" TEXT,
-  "iri" TEXT NOT NULL constraint objects_key unique,
-  "id" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.id')) VIRTUAL ,
-  "type" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.type')) VIRTUAL,
-  "to" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.to')) VIRTUAL,
-  "bto" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.bto')) VIRTUAL,
-  "cc" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.cc')) VIRTUAL,
-  "bcc" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.bcc')) VIRTUAL,
-  "published" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.published')) VIRTUAL,
-  "updated" TEXT GENERATED ALWAYS AS (coalesce(json_extract(raw, '$.updated'), json_extract(raw, '$.deleted'), json_extract(raw, '$.published'))) VIRTUAL,
-  "url" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.url')) VIRTUAL,
-  "name" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.name')) VIRTUAL,
-  "summary" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.summary')) VIRTUAL,
-  "content" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.content')) VIRTUAL
+	// createSearchQuery creates the full text search index for objects and actors.
+	// The search_docs table maps the IRIs to stable row ids in the FTS5 table, because the rows of the
+	// objects and actors tables get replaced on every save.
+	//
+	// NOTE(marius): the index is kept in sync with the two tables by the save and delete operations, and not
+	// by triggers, because the HTML markup gets removed from the indexed values in Go.
+	createSearchQuery = `
+CREATE TABLE IF NOT EXISTS search_docs (
+  "id" INTEGER PRIMARY KEY,
+  "iri" TEXT NOT NULL constraint search_docs_key unique
 ) STRICT;
-CREATE INDEX objects_type ON objects(type);
-CREATE INDEX objects_name ON objects(name);
-CREATE INDEX objects_content ON objects(content);
-CREATE INDEX objects_published ON objects(published);
-CREATE INDEX objects_updated ON objects(updated);
+CREATE VIRTUAL TABLE IF NOT EXISTS search USING fts5(
+  name, preferred_username, summary, content,
+  tokenize = 'unicode61 remove_diacritics 2'
+);
 `
 
-	createCollectionsQuery = `
-CREATE TABLE IF NOT EXISTS collections (
-  "raw" TEXT,
-  "iri" TEXT NOT NULL constraint collections_key unique,
-  "id" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.id')) VIRTUAL,
-  "type" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.type')) VIRTUAL,
-  "to" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.to')) VIRTUAL,
-  "bto" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.bto')) VIRTUAL,
-  "cc" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.cc')) VIRTUAL,
-  "bcc" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.bcc')) VIRTUAL,
-  "published" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.published')) VIRTUAL,
-  "updated" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.updated')) VIRTUAL,
-  "items" TEXT DEFAULT '[]'
+	// createChangesQuery creates the log of the changed IRIs, and the triggers that populate it when the
+	// items get saved or deleted.
+	createChangesQuery = `
+CREATE TABLE IF NOT EXISTS changes (
+  "seq" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "iri" TEXT NOT NULL,
+  "published" TEXT default CURRENT_TIMESTAMP
 ) STRICT;
-CREATE INDEX collections_type ON collections(type);
-CREATE INDEX collections_published ON collections(published);
-CREATE INDEX collections_updated ON collections(updated);
+CREATE TRIGGER IF NOT EXISTS objects_changes_insert AFTER INSERT ON objects BEGIN
+  INSERT INTO changes (iri) VALUES (new.iri);
+END;
+CREATE TRIGGER IF NOT EXISTS objects_changes_update AFTER UPDATE ON objects BEGIN
+  INSERT INTO changes (iri) VALUES (new.iri);
+END;
+CREATE TRIGGER IF NOT EXISTS objects_changes_delete AFTER DELETE ON objects BEGIN
+  INSERT INTO changes (iri) VALUES (old.iri);
+END;
+CREATE TRIGGER IF NOT EXISTS activities_changes_insert AFTER INSERT ON activities BEGIN
+  INSERT INTO changes (iri) VALUES (new.iri);
+END;
+CREATE TRIGGER IF NOT EXISTS activities_changes_update AFTER UPDATE ON activities BEGIN
+  INSERT INTO changes (iri) VALUES (new.iri);
+END;
+CREATE TRIGGER IF NOT EXISTS activities_changes_delete AFTER DELETE ON activities BEGIN
+  INSERT INTO changes (iri) VALUES (old.iri);
+END;
+CREATE TRIGGER IF NOT EXISTS actors_changes_insert AFTER INSERT ON actors BEGIN
+  INSERT INTO changes (iri) VALUES (new.iri);
+END;
+CREATE TRIGGER IF NOT EXISTS actors_changes_update AFTER UPDATE ON actors BEGIN
+  INSERT INTO changes (iri) VALUES (new.iri);
+END;
+CREATE TRIGGER IF NOT EXISTS actors_changes_delete AFTER DELETE ON actors BEGIN
+  INSERT INTO changes (iri) VALUES (old.iri);
+END;
+CREATE TRIGGER IF NOT EXISTS collections_changes_insert AFTER INSERT ON collections BEGIN
+  INSERT INTO changes (iri) VALUES (new.iri);
+END;
+CREATE TRIGGER IF NOT EXISTS collections_changes_update AFTER UPDATE ON collections BEGIN
+  INSERT INTO changes (iri) VALUES (new.iri);
+END;
+CREATE TRIGGER IF NOT EXISTS collections_changes_delete AFTER DELETE ON collections BEGIN
+  INSERT INTO changes (iri) VALUES (old.iri);
+END;
+`
+
+	// createChangeFeedQuery adds the type of the change to the changes log, and the triggers that log
+	// the items added to and removed from collections.
+	// The item column holds the IRI of the item added or removed, the iri column the one of the collection.
+	createChangeFeedQuery = `
+ALTER TABLE changes ADD COLUMN "type" TEXT NOT NULL DEFAULT 'save';
+ALTER TABLE changes ADD COLUMN "item" TEXT;
+DROP TRIGGER IF EXISTS objects_changes_delete;
+CREATE TRIGGER IF NOT EXISTS objects_changes_delete AFTER DELETE ON objects BEGIN
+  INSERT INTO changes (type, iri) VALUES ('delete', old.iri);
+END;
+DROP TRIGGER IF EXISTS activities_changes_delete;
+CREATE TRIGGER IF NOT EXISTS activities_changes_delete AFTER DELETE ON activities BEGIN
+  INSERT INTO changes (type, iri) VALUES ('delete', old.iri);
+END;
+DROP TRIGGER IF EXISTS actors_changes_delete;
+CREATE TRIGGER IF NOT EXISTS actors_changes_delete AFTER DELETE ON actors BEGIN
+  INSERT INTO changes (type, iri) VALUES ('delete', old.iri);
+END;
+DROP TRIGGER IF EXISTS collections_changes_delete;
+CREATE TRIGGER IF NOT EXISTS collections_changes_delete AFTER DELETE ON collections BEGIN
+  INSERT INTO changes (type, iri) VALUES ('delete', old.iri);
+END;
+CREATE TRIGGER IF NOT EXISTS collection_items_changes_insert AFTER INSERT ON collection_items BEGIN
+  INSERT INTO changes (type, iri, item) VALUES ('add', new.collection_iri, new.item_iri);
+END;
+CREATE TRIGGER IF NOT EXISTS collection_items_changes_delete AFTER DELETE ON collection_items BEGIN
+  INSERT INTO changes (type, iri, item) VALUES ('remove', old.collection_iri, old.item_iri);
+END;
 `
 
-	createMetaQuery = `
-CREATE TABLE IF NOT EXISTS meta (
-  "iri" TEXT NOT NULL constraint meta_key unique,
+	// createRevisionsQuery creates the table that holds the previous versions of the objects and actors.
+	createRevisionsQuery = `
+CREATE TABLE IF NOT EXISTS revisions (
+  "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "iri" TEXT NOT NULL,
   "raw" TEXT,
+  "activity" TEXT,
   "published" TEXT default CURRENT_TIMESTAMP
 ) STRICT;
+CREATE INDEX IF NOT EXISTS revisions_iri ON revisions(iri, id);
+`
+
+	// createCollectionItemsReverseIndexQuery indexes the collection items by their IRI, for looking up
+	// the collections that contain an item.
+	createCollectionItemsReverseIndexQuery = `
+CREATE INDEX IF NOT EXISTS collection_items_item ON collection_items(item_iri);
 `
 )
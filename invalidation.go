@@ -0,0 +1,188 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// cacheInvalidator holds the state of the goroutine that removes from the cache the items changed
+// by other processes.
+type cacheInvalidator struct {
+	stop context.CancelFunc
+	done chan struct{}
+
+	// conn is the connection used to check the data version, the value of the pragma is meaningful
+	// only when compared to a previous one from the same connection.
+	conn        *sql.Conn
+	dataVersion int64
+	// lastSeq is the sequence number of the last change that was checked, PruneChanges doesn't remove
+	// the changes after it.
+	lastSeq atomic.Int64
+
+	mu sync.Mutex
+	// own are the ranges of the sequence numbers of the changes made by this process, which have not been checked yet.
+	own []seqRange
+}
+
+// seqRange are the sequence numbers of the changes made by a transaction, from is not part of it.
+type seqRange struct {
+	from, to int64
+}
+
+// addOwn marks the changes with sequence numbers after from, up to to, as made by this process.
+func (c *cacheInvalidator) addOwn(from, to int64) {
+	if to <= from {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.own = append(c.own, seqRange{from: from, to: to})
+}
+
+// isOwn returns if the change with sequence number seq has been made by this process.
+func (c *cacheInvalidator) isOwn(seq int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rng := range c.own {
+		if seq > rng.from && seq <= rng.to {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneOwn forgets the ranges of changes made by this process that are not after seq.
+func (c *cacheInvalidator) pruneOwn(seq int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.own = slices.DeleteFunc(c.own, func(rng seqRange) bool { return rng.to <= seq })
+}
+
+// startCacheInvalidator starts the goroutine that checks the database for changes made by other processes
+// every r.invalidationInterval.
+func (r *repo) startCacheInvalidator() error {
+	if r.invalidationInterval <= 0 || r.cache == nil || r.invalidator != nil {
+		return nil
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	c := cacheInvalidator{stop: stop, done: make(chan struct{})}
+
+	// NOTE(marius): the connection is held by the invalidator until it's stopped, so the read pool gets one more
+	r.ro.SetMaxOpenConns(r.connOpts.readPoolSize() + 1)
+	var err error
+	if c.conn, err = r.ro.Conn(ctx); err != nil {
+		stop()
+		return wrapSQLError(err, "unable to open the cache invalidation connection")
+	}
+	if c.dataVersion, err = dataVersion(ctx, c.conn); err != nil {
+		stop()
+		_ = c.conn.Close()
+		return err
+	}
+	seq, err := loadLastChangeSeq(ctx, c.conn)
+	if err != nil {
+		stop()
+		_ = c.conn.Close()
+		return err
+	}
+	c.lastSeq.Store(seq)
+	r.invalidator = &c
+
+	go func() {
+		defer close(c.done)
+
+		ticker := time.NewTicker(r.invalidationInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.invalidateChanged(ctx, &c); err != nil && !errors.Is(err, context.Canceled) {
+					r.errFn("%s", errors.Annotatef(err, "cache invalidation error"))
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// stopCacheInvalidator stops the cache invalidation goroutine, and waits for it to finish.
+func (r *repo) stopCacheInvalidator() {
+	if r.invalidator == nil {
+		return
+	}
+	r.invalidator.stop()
+	<-r.invalidator.done
+	if r.invalidator.conn != nil {
+		_ = r.invalidator.conn.Close()
+		r.ro.SetMaxOpenConns(r.connOpts.readPoolSize())
+	}
+	r.invalidator = nil
+}
+
+// dataVersion returns the value of the data_version pragma for conn, which changes every time
+// another connection, from this process or from another one, commits a change to the database.
+func dataVersion(ctx context.Context, conn *sql.Conn) (int64, error) {
+	var v int64
+	if err := conn.QueryRowContext(ctx, "PRAGMA data_version;").Scan(&v); err != nil {
+		return 0, wrapSQLError(err, "unable to load data version")
+	}
+	return v, nil
+}
+
+// invalidateChanged removes from the cache the IRIs found in the changes log since the last check,
+// if the database has been modified by another process.
+func (r *repo) invalidateChanged(ctx context.Context, c *cacheInvalidator) error {
+	// NOTE(marius): the changes committed after loading the data version are found by this check,
+	// or by the next one, as the data version changes again.
+	v, err := dataVersion(ctx, c.conn)
+	if err != nil {
+		return err
+	}
+	if v == c.dataVersion {
+		return nil
+	}
+
+	rows, err := c.conn.QueryContext(ctx, "SELECT seq, iri FROM changes WHERE seq > ? ORDER BY seq;", c.lastSeq.Load())
+	if err != nil {
+		return wrapSQLError(err, "unable to load changes")
+	}
+	defer rows.Close()
+
+	lastSeq := c.lastSeq.Load()
+	iris := make(vocab.IRIs, 0)
+	for rows.Next() {
+		var iri vocab.IRI
+		if err = rows.Scan(&lastSeq, &iri); err != nil {
+			return wrapSQLError(err, "scan values error")
+		}
+		// NOTE(marius): the changes made by this process don't need to be removed from the cache
+		if c.isOwn(lastSeq) {
+			continue
+		}
+		iris = append(iris, iri)
+	}
+	if err = rows.Err(); err != nil {
+		return wrapSQLError(err, "unable to load changes")
+	}
+
+	for _, iri := range iris {
+		r.cache.Delete(iri)
+	}
+	if len(iris) > 0 {
+		r.logFn("removed %d changed items from the cache", len(iris))
+	}
+	c.dataVersion = v
+	c.lastSeq.Store(lastSeq)
+	c.pruneOwn(lastSeq)
+	return nil
+}
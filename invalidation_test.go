@@ -0,0 +1,146 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_repo_invalidateChanged(t *testing.T) {
+	ctx := context.Background()
+	ob := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType, Name: vocab.DefaultNaturalLanguage("initial")}
+	updated := &vocab.Object{ID: ob.ID, Type: vocab.NoteType, Name: vocab.DefaultNaturalLanguage("updated")}
+	other := &vocab.Object{ID: "https://example.com/objects/2", Type: vocab.NoteType}
+
+	path := t.TempDir()
+	be.NilErr(t, Bootstrap(Config{Path: path}))
+
+	// NOTE(marius): the interval is long enough for the invalidation to never run in the background,
+	// so we can run it by hand.
+	c := NewLRUCache(10, 0)
+	r, err := New(Config{Path: path, Cache: c, CacheInvalidationInterval: time.Hour, LogFn: t.Logf, ErrFn: t.Errorf})
+	be.NilErr(t, err)
+	be.NilErr(t, r.Open())
+	t.Cleanup(r.Close)
+	be.True(t, r.invalidator != nil)
+
+	// NOTE(marius): the second repository simulates another process writing to the same database
+	r2, err := New(Config{Path: path, LogFn: t.Logf, ErrFn: t.Errorf})
+	be.NilErr(t, err)
+	be.NilErr(t, r2.Open())
+	t.Cleanup(r2.Close)
+
+	_, err = r.Save(ob)
+	be.NilErr(t, err)
+	_, err = r.Save(other)
+	be.NilErr(t, err)
+
+	// changes made by the same repository don't invalidate its cache
+	be.NilErr(t, r.invalidateChanged(ctx, r.invalidator))
+	be.True(t, c.Load(ob.ID) != nil)
+	be.True(t, c.Load(other.ID) != nil)
+	// and they are marked as checked
+	seq, err := r.lastChangeSeq(ctx)
+	be.NilErr(t, err)
+	be.Equal(t, seq, r.invalidator.lastSeq.Load())
+
+	_, err = r2.Save(updated)
+	be.NilErr(t, err)
+	be.NilErr(t, r.invalidateChanged(ctx, r.invalidator))
+	be.True(t, c.Load(ob.ID) == nil)
+	be.True(t, c.Load(other.ID) != nil)
+
+	it, err := r.Load(ob.ID)
+	be.NilErr(t, err)
+	be.NilErr(t, vocab.OnObject(it, func(o *vocab.Object) error {
+		if !cmp.Equal(updated.Name, o.Name) {
+			t.Errorf("Load() returned stale item: %s", cmp.Diff(updated.Name, o.Name))
+		}
+		return nil
+	}))
+
+	be.NilErr(t, r2.Delete(other))
+	be.NilErr(t, r.invalidateChanged(ctx, r.invalidator))
+	be.True(t, c.Load(other.ID) == nil)
+}
+
+func Test_repo_startCacheInvalidator(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		cache    bool
+		want     bool
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name:     "no cache",
+			interval: time.Second,
+		},
+		{
+			name:     "enabled",
+			interval: time.Second,
+			cache:    true,
+			want:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap)
+			t.Cleanup(r.Close)
+			if tt.cache {
+				r.cache = NewLRUCache(10, 0)
+			}
+			r.invalidationInterval = tt.interval
+
+			be.NilErr(t, r.startCacheInvalidator())
+			be.Equal(t, tt.want, r.invalidator != nil)
+			r.stopCacheInvalidator()
+			be.True(t, r.invalidator == nil)
+		})
+	}
+}
+
+func Test_repo_invalidateChanged_interleavedWrites(t *testing.T) {
+	ctx := context.Background()
+	ob := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType}
+	other := &vocab.Object{ID: "https://example.com/objects/2", Type: vocab.NoteType}
+
+	path := t.TempDir()
+	be.NilErr(t, Bootstrap(Config{Path: path}))
+
+	c := NewLRUCache(10, 0)
+	r, err := New(Config{Path: path, Cache: c, CacheInvalidationInterval: time.Hour, LogFn: t.Logf, ErrFn: t.Errorf})
+	be.NilErr(t, err)
+	be.NilErr(t, r.Open())
+	t.Cleanup(r.Close)
+
+	r2, err := New(Config{Path: path, LogFn: t.Logf, ErrFn: t.Errorf})
+	be.NilErr(t, err)
+	be.NilErr(t, r2.Open())
+	t.Cleanup(r2.Close)
+
+	// NOTE(marius): the data version changes for the writes of both repositories, only the changes
+	// made by the other one need to be removed from the cache
+	_, err = r.Save(ob)
+	be.NilErr(t, err)
+	_, err = r2.Save(ob)
+	be.NilErr(t, err)
+	_, err = r.Save(other)
+	be.NilErr(t, err)
+	c.Store(ob.ID, ob)
+
+	be.NilErr(t, r.invalidateChanged(ctx, r.invalidator))
+	be.True(t, c.Load(ob.ID) == nil)
+	be.True(t, c.Load(other.ID) != nil)
+
+	seq, err := r.lastChangeSeq(ctx)
+	be.NilErr(t, err)
+	be.Equal(t, seq, r.invalidator.lastSeq.Load())
+	be.Equal(t, 0, len(r.invalidator.own))
+}
@@ -0,0 +1,82 @@
+package sqlite
+
+import (
+	"context"
+	"iter"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+	"github.com/leporo/sqlf"
+)
+
+// LoadIter returns an iterator over the items found at iri.
+// Unlike LoadContext, the items are decoded one at a time while reading the rows from the database,
+// so loading a large collection doesn't keep all of it in memory.
+//
+// For collection IRIs the iterator yields the items of the collection, ordered by their published date,
+// newest first. The errors that stop the iteration, and the ones for rows that can't be decoded, are
+// yielded with a nil item, the caller can skip the latter by continuing the loop.
+func (r *repo) LoadIter(ctx context.Context, iri vocab.IRI, ff ...filters.Check) iter.Seq2[vocab.Item, error] {
+	return func(yield func(vocab.Item, error) bool) {
+		if r == nil || r.ro == nil {
+			yield(nil, errNotOpen)
+			return
+		}
+		if iri == "" {
+			yield(nil, errors.NotFoundf("not found"))
+			return
+		}
+
+		isCollection := isCollectionIRI(iri)
+		var st *sqlf.Stmt
+		if isCollection {
+			items := collectionItemsQuery(colIRI(iri), nil, ff...)
+			st = sqlf.From("("+items.String()+") AS i", items.Args()...)
+			st.Select("i.raw")
+			st.Where("i.raw IS NOT NULL")
+			st.OrderBy("i.published DESC", "i.iri DESC")
+		} else {
+			ff = append(filters.Checks{filters.SameID(iri)}, ff...)
+			st = threeTablesQuery(ff...)
+		}
+
+		rows, err := r.reader().QueryContext(ctx, st.String(), st.Args()...)
+		if err != nil {
+			yield(nil, wrapSQLError(err, "unable to run select"))
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var raw []byte
+			if err = rows.Scan(&raw); err != nil {
+				yield(nil, wrapSQLError(err, "scan values error"))
+				return
+			}
+			it, err := decodeItemFn(raw)
+			if err != nil {
+				if !yield(nil, errors.Annotatef(err, "unable to unmarshal raw item")) {
+					return
+				}
+				continue
+			}
+			if !isCollection && vocab.IsObject(it) && r.cache != nil && r.tx == nil {
+				r.cache.Store(it.GetLink(), it)
+			}
+			it = firstOrItems(dereferencePropertiesByType(r, ctx, it, ff...))
+			if isCollection {
+				// NOTE(marius): not all the filters can be converted to SQL, so we check the items again here
+				if it = filters.Checks(ff).Run(it); vocab.IsNil(it) {
+					continue
+				}
+			}
+			if !yield(it, nil) {
+				return
+			}
+		}
+		if err = rows.Err(); err != nil {
+			yield(nil, wrapSQLError(err, "unable to load items"))
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_repo_LoadIter(t *testing.T) {
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap, withGeneratedMocks)
+	t.Cleanup(r.Close)
+
+	tests := []struct {
+		name    string
+		repo    *repo
+		iri     vocab.IRI
+		ff      filters.Checks
+		want    vocab.IRIs
+		wantErr error
+	}{
+		{
+			name:    "not open",
+			repo:    &repo{},
+			iri:     rootOutboxIRI,
+			wantErr: errNotOpen,
+		},
+		{
+			name:    "empty iri",
+			repo:    r,
+			wantErr: errors.NotFoundf("not found"),
+		},
+		{
+			name: "single item",
+			repo: r,
+			iri:  "https://example.com/person/1",
+			want: vocab.IRIs{"https://example.com/person/1"},
+		},
+		{
+			name: "missing item",
+			repo: r,
+			iri:  "https://example.com/person/1000",
+			want: vocab.IRIs{},
+		},
+		{
+			name: "collection",
+			repo: r,
+			iri:  rootOutboxIRI,
+			want: allActivities.Load().IRIs(),
+		},
+		{
+			name: "collection with filters",
+			repo: r,
+			iri:  rootOutboxIRI,
+			ff:   filters.Checks{filters.HasType(vocab.CreateType)},
+			want: filter(*allActivities.Load(), filters.HasType(vocab.CreateType)).IRIs(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(vocab.IRIs, 0)
+			for it, err := range tt.repo.LoadIter(context.Background(), tt.iri, tt.ff...) {
+				if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+					t.Errorf("LoadIter() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+				}
+				if err != nil {
+					continue
+				}
+				got = append(got, it.GetLink())
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			be.Equal(t, len(tt.want), len(got))
+			for _, iri := range tt.want {
+				if !got.Contains(iri) {
+					t.Errorf("LoadIter() did not return %s", iri)
+				}
+			}
+		})
+	}
+
+	t.Run("stops when the loop breaks", func(t *testing.T) {
+		count := 0
+		for range r.LoadIter(context.Background(), rootOutboxIRI) {
+			count++
+			if count == 2 {
+				break
+			}
+		}
+		be.Equal(t, 2, count)
+	})
+}
@@ -0,0 +1,58 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+const selectCollectionsContaining = "SELECT collection_iri FROM collection_items WHERE item_iri = ? ORDER BY collection_iri;"
+
+// CollectionsContaining returns the IRIs of the collections that have iri as one of their items.
+func (r *repo) CollectionsContaining(ctx context.Context, iri vocab.IRI) (vocab.IRIs, error) {
+	if r == nil || r.ro == nil {
+		return nil, errNotOpen
+	}
+	return selectIRIs(ctx, r.reader(), selectCollectionsContaining, iri)
+}
+
+// removeFromAllCollections removes iri from all the collections that contain it, and updates their totalItems.
+// It returns the number of collections it was removed from, and the IRIs of the changed collections, which
+// need to be removed from the cache after the transaction is committed.
+func (r *repo) removeFromAllCollections(ctx context.Context, tx *sql.Tx, iri vocab.IRI) (int64, vocab.IRIs, error) {
+	cols, err := selectIRIs(ctx, tx, selectCollectionsContaining, iri)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(cols) == 0 {
+		return 0, nil, nil
+	}
+
+	// NOTE(marius): the collections are loaded before removing iri, so the items still embedded in their raw
+	// values are moved to the collection_items table first, and can't bring it back.
+	loaded := make([]vocab.Item, 0, len(cols))
+	for _, col := range cols {
+		c, err := r.loadCollectionForUpdate(ctx, tx, col)
+		if err != nil {
+			// NOTE(marius): the collection object can be missing, while its items are still stored
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			return 0, nil, err
+		}
+		loaded = append(loaded, c)
+	}
+
+	n, err := rowsAffected(tx.ExecContext(ctx, "DELETE FROM collection_items WHERE item_iri = ?;", iri))
+	if err != nil {
+		return 0, nil, wrapSQLError(err, "unable to remove %s from collections", iri)
+	}
+	for _, c := range loaded {
+		if err = r.updateCollectionTotalItems(ctx, tx, c); err != nil {
+			return n, nil, err
+		}
+	}
+	return n, cols, nil
+}
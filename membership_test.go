@@ -0,0 +1,133 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_repo_CollectionsContaining(t *testing.T) {
+	outbox := &vocab.OrderedCollection{ID: "https://example.com/actors/1/outbox", Type: vocab.OrderedCollectionType}
+	liked := &vocab.OrderedCollection{ID: "https://example.com/actors/1/liked", Type: vocab.OrderedCollectionType}
+	note := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType}
+	other := &vocab.Object{ID: "https://example.com/objects/2", Type: vocab.NoteType}
+
+	withMembers := func(t *testing.T, r *repo) *repo {
+		withGeneratedItems(vocab.ItemCollection{outbox, liked, note, other})(t, r)
+		be.NilErr(t, r.AddTo(outbox.ID, note, other))
+		be.NilErr(t, r.AddTo(liked.ID, note))
+		return r
+	}
+
+	tests := []struct {
+		name     string
+		setupFns []initFn
+		iri      vocab.IRI
+		want     vocab.IRIs
+		wantErr  error
+	}{
+		{
+			name:    "not open",
+			iri:     note.ID,
+			wantErr: errNotOpen,
+		},
+		{
+			name:     "empty",
+			setupFns: []initFn{withOpenRoot, withBootstrap},
+			iri:      note.ID,
+			want:     vocab.IRIs{},
+		},
+		{
+			name:     "in two collections",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withMembers},
+			iri:      note.ID,
+			want:     vocab.IRIs{liked.ID, outbox.ID},
+		},
+		{
+			name:     "in one collection",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withMembers},
+			iri:      other.ID,
+			want:     vocab.IRIs{outbox.ID},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mockRepo(t, fields{path: t.TempDir()}, tt.setupFns...)
+			t.Cleanup(r.Close)
+
+			got, err := r.CollectionsContaining(context.Background(), tt.iri)
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Fatalf("CollectionsContaining() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+			}
+			be.AllEqual(t, tt.want, got)
+		})
+	}
+}
+
+func Test_repo_Delete_removesFromCollections(t *testing.T) {
+	outbox := &vocab.OrderedCollection{ID: "https://example.com/actors/1/outbox", Type: vocab.OrderedCollectionType}
+	liked := &vocab.OrderedCollection{ID: "https://example.com/actors/1/liked", Type: vocab.OrderedCollectionType}
+	note := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType}
+	other := &vocab.Object{ID: "https://example.com/objects/2", Type: vocab.NoteType}
+
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{outbox, liked, note, other}))
+	t.Cleanup(r.Close)
+
+	be.NilErr(t, r.AddTo(outbox.ID, note, other))
+	be.NilErr(t, r.AddTo(liked.ID, note))
+
+	be.NilErr(t, r.Delete(note))
+
+	cols, err := r.CollectionsContaining(context.Background(), note.ID)
+	be.NilErr(t, err)
+	be.Equal(t, 0, len(cols))
+
+	wantTotals := map[vocab.IRI]uint{outbox.ID: 1, liked.ID: 0}
+	for iri, want := range wantTotals {
+		it, err := r.Load(iri)
+		be.NilErr(t, err)
+		err = vocab.OnOrderedCollection(it, func(c *vocab.OrderedCollection) error {
+			be.Equal(t, want, c.TotalItems)
+			be.False(t, c.OrderedItems.Contains(note.ID))
+			return nil
+		})
+		be.NilErr(t, err)
+	}
+}
+
+func Test_repo_Delete_removesFromCollectionsWithEmbeddedItems(t *testing.T) {
+	note := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType}
+	other := &vocab.Object{ID: "https://example.com/objects/2", Type: vocab.NoteType}
+	// NOTE(marius): the collection still has its items embedded in its raw value, as it was saved before
+	// the collection_items table existed
+	outbox := &vocab.OrderedCollection{
+		ID:           "https://example.com/actors/1/outbox",
+		Type:         vocab.OrderedCollectionType,
+		OrderedItems: vocab.ItemCollection{note.ID, other.ID},
+	}
+
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{outbox, note, other}))
+	t.Cleanup(r.Close)
+
+	_, err := r.conn.Exec("INSERT INTO collection_items (collection_iri, item_iri, position) VALUES (?, ?, 1);", outbox.ID, note.ID)
+	be.NilErr(t, err)
+
+	be.NilErr(t, r.Delete(note))
+
+	cols, err := r.CollectionsContaining(context.Background(), note.ID)
+	be.NilErr(t, err)
+	be.Equal(t, 0, len(cols))
+
+	it, err := r.Load(outbox.ID)
+	be.NilErr(t, err)
+	err = vocab.OnOrderedCollection(it, func(c *vocab.OrderedCollection) error {
+		be.Equal(t, uint(1), c.TotalItems)
+		be.False(t, c.OrderedItems.Contains(note.ID))
+		be.True(t, c.OrderedItems.Contains(other.ID))
+		return nil
+	})
+	be.NilErr(t, err)
+}
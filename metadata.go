@@ -1,6 +1,7 @@
 package sqlite
 
 import (
+	"context"
 	"crypto"
 	"crypto/dsa"
 	"crypto/ecdsa"
@@ -23,6 +24,11 @@ type Metadata struct {
 
 // PasswordSet
 func (r *repo) PasswordSet(iri vocab.IRI, pw []byte) error {
+	return r.PasswordSetContext(context.Background(), iri, pw)
+}
+
+// PasswordSetContext
+func (r *repo) PasswordSetContext(ctx context.Context, iri vocab.IRI, pw []byte) error {
 	if r == nil || r.conn == nil {
 		return errNotOpen
 	}
@@ -34,7 +40,7 @@ func (r *repo) PasswordSet(iri vocab.IRI, pw []byte) error {
 	}
 
 	m := new(Metadata)
-	if err := r.LoadMetadata(iri, m); err != nil && !errors.IsNotFound(err) {
+	if err := r.LoadMetadataContext(ctx, iri, m); err != nil && !errors.IsNotFound(err) {
 		return err
 	}
 
@@ -43,16 +49,21 @@ func (r *repo) PasswordSet(iri vocab.IRI, pw []byte) error {
 	if err != nil {
 		return errors.Annotatef(err, "could not generate password hash")
 	}
-	return r.SaveMetadata(iri, m)
+	return r.SaveMetadataContext(ctx, iri, m)
 }
 
 // PasswordCheck
 func (r *repo) PasswordCheck(iri vocab.IRI, pw []byte) error {
+	return r.PasswordCheckContext(context.Background(), iri, pw)
+}
+
+// PasswordCheckContext
+func (r *repo) PasswordCheckContext(ctx context.Context, iri vocab.IRI, pw []byte) error {
 	if r == nil || r.ro == nil {
 		return errNotOpen
 	}
 	m := new(Metadata)
-	if err := r.LoadMetadata(iri, m); err != nil {
+	if err := r.LoadMetadataContext(ctx, iri, m); err != nil {
 		return err
 	}
 	if err := bcrypt.CompareHashAndPassword(m.Pw, pw); err != nil {
@@ -63,10 +74,15 @@ func (r *repo) PasswordCheck(iri vocab.IRI, pw []byte) error {
 
 // LoadMetadata
 func (r *repo) LoadMetadata(iri vocab.IRI, m any) error {
+	return r.LoadMetadataContext(context.Background(), iri, m)
+}
+
+// LoadMetadataContext
+func (r *repo) LoadMetadataContext(ctx context.Context, iri vocab.IRI, m any) error {
 	if r == nil || r.ro == nil {
 		return errNotOpen
 	}
-	raw, err := loadMetadataFromTable(r.ro, iri)
+	raw, err := loadMetadataFromTable(r.ro, ctx, iri)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return errors.NewNotFound(err, "could not find metadata in path")
@@ -85,6 +101,11 @@ func (r *repo) LoadMetadata(iri vocab.IRI, m any) error {
 
 // SaveMetadata
 func (r *repo) SaveMetadata(iri vocab.IRI, m any) error {
+	return r.SaveMetadataContext(context.Background(), iri, m)
+}
+
+// SaveMetadataContext
+func (r *repo) SaveMetadataContext(ctx context.Context, iri vocab.IRI, m any) error {
 	if r == nil || r.conn == nil {
 		return errNotOpen
 	}
@@ -95,16 +116,23 @@ func (r *repo) SaveMetadata(iri vocab.IRI, m any) error {
 	if err != nil {
 		return errors.Annotatef(err, "Could not marshal metadata")
 	}
-	return saveMetadataToTable(r.conn, iri, entryBytes)
+	return r.retry(ctx, func() error {
+		return saveMetadataToTable(r.conn, ctx, iri, entryBytes)
+	})
 }
 
 // LoadKey loads a private key for an actor found by its IRI
 func (r *repo) LoadKey(iri vocab.IRI) (crypto.PrivateKey, error) {
+	return r.LoadKeyContext(context.Background(), iri)
+}
+
+// LoadKeyContext loads a private key for an actor found by its IRI
+func (r *repo) LoadKeyContext(ctx context.Context, iri vocab.IRI) (crypto.PrivateKey, error) {
 	if r == nil || r.ro == nil {
 		return nil, errNotOpen
 	}
 	m := new(Metadata)
-	if err := r.LoadMetadata(iri, m); err != nil {
+	if err := r.LoadMetadataContext(ctx, iri, m); err != nil {
 		return nil, err
 	}
 	b, _ := pem.Decode(m.PrivateKey)
@@ -120,11 +148,16 @@ func (r *repo) LoadKey(iri vocab.IRI) (crypto.PrivateKey, error) {
 
 // SaveKey saves a private key for an actor found by its IRI
 func (r *repo) SaveKey(iri vocab.IRI, key crypto.PrivateKey) (*vocab.PublicKey, error) {
+	return r.SaveKeyContext(context.Background(), iri, key)
+}
+
+// SaveKeyContext saves a private key for an actor found by its IRI
+func (r *repo) SaveKeyContext(ctx context.Context, iri vocab.IRI, key crypto.PrivateKey) (*vocab.PublicKey, error) {
 	if r == nil || r.conn == nil {
 		return nil, errNotOpen
 	}
 	m := new(Metadata)
-	if err := r.LoadMetadata(iri, m); err != nil && !errors.IsNotFound(err) {
+	if err := r.LoadMetadataContext(ctx, iri, m); err != nil && !errors.IsNotFound(err) {
 		return nil, err
 	}
 	if m.PrivateKey != nil {
@@ -139,7 +172,7 @@ func (r *repo) SaveKey(iri vocab.IRI, key crypto.PrivateKey) (*vocab.PublicKey,
 		Type:  "PRIVATE KEY",
 		Bytes: prvEnc,
 	})
-	if err = r.SaveMetadata(iri, m); err != nil {
+	if err = r.SaveMetadataContext(ctx, iri, m); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,335 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/go-ap/errors"
+)
+
+type migration struct {
+	version int
+	name    string
+	query   string
+	// when, if set, checks if the migration needs to run its query on the current database.
+	// Its version gets recorded regardless.
+	when func(ctx context.Context, conn querier) (bool, error)
+	// fn, if set, runs after the query, in the same transaction, for the changes that can't be done in SQL.
+	fn func(ctx context.Context, tx *sql.Tx) error
+}
+
+// migrations is the ordered list of changes to the database schema.
+// The index of the latest migration that was applied is stored in the "user_version" pragma of the database.
+//
+// NOTE(marius): the list is append only, and the queries of a migration must not be changed
+// after it was released, as that would leave existing databases in an inconsistent state.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "initial schema",
+		query:   initialSchemaQuery,
+	},
+	{
+		version: 2,
+		name:    "collection items table",
+		query:   createCollectionItemsQuery,
+	},
+	{
+		version: 3,
+		name:    "move collection items from JSON arrays",
+		query:   migrateCollectionItemsQuery,
+		when:    hasColumn("collections", "items"),
+	},
+	{
+		version: 4,
+		name:    "full text search index",
+		query:   createSearchQuery,
+		// NOTE(marius): the mattn driver has the FTS5 module only when built with the "sqlite_fts5" tag.
+		// Without it the database doesn't get the search index, and Search returns an error.
+		when: hasModule("fts5"),
+		fn:   indexAllForSearch,
+	},
+	{
+		version: 5,
+		name:    "changes log",
+		query:   createChangesQuery,
+	},
+	{
+		version: 6,
+		name:    "change feed",
+		query:   createChangeFeedQuery,
+	},
+	{
+		version: 7,
+		name:    "revisions",
+		query:   createRevisionsQuery,
+	},
+	{
+		version: 8,
+		name:    "collection items reverse index",
+		query:   createCollectionItemsReverseIndexQuery,
+	},
+}
+
+// initialSchemaQuery creates the tables of the first version of the schema, for the ActivityPub items and the OAuth2 data.
+//
+// NOTE(marius): this is a frozen copy of the schema, the changes to the tables go in new migrations.
+const initialSchemaQuery = `
+CREATE TABLE IF NOT EXISTS objects (
+  "raw" TEXT,
+  "iri" TEXT NOT NULL constraint objects_key unique,
+  "id" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.id')) VIRTUAL ,
+  "type" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.type')) VIRTUAL,
+  "to" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.to')) VIRTUAL,
+  "bto" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.bto')) VIRTUAL,
+  "cc" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.cc')) VIRTUAL,
+  "bcc" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.bcc')) VIRTUAL,
+  "published" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.published')) VIRTUAL,
+  "updated" TEXT GENERATED ALWAYS AS (coalesce(json_extract(raw, '$.updated'), json_extract(raw, '$.deleted'), json_extract(raw, '$.published'))) VIRTUAL,
+  "url" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.url')) VIRTUAL,
+  "name" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.name')) VIRTUAL,
+  "summary" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.summary')) VIRTUAL,
+  "content" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.content')) VIRTUAL
+) STRICT;
+CREATE INDEX IF NOT EXISTS objects_type ON objects(type);
+CREATE INDEX IF NOT EXISTS objects_name ON objects(name);
+CREATE INDEX IF NOT EXISTS objects_content ON objects(content);
+CREATE INDEX IF NOT EXISTS objects_published ON objects(published);
+CREATE INDEX IF NOT EXISTS objects_updated ON objects(updated);
+
+CREATE TABLE IF NOT EXISTS activities (
+  "raw" TEXT,
+  "iri" TEXT NOT NULL constraint activities_key unique,
+  "id" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.id')) VIRTUAL ,
+  "type" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.type')) VIRTUAL,
+  "to" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.to')) VIRTUAL,
+  "bto" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.bto')) VIRTUAL,
+  "cc" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.cc')) VIRTUAL,
+  "bcc" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.bcc')) VIRTUAL,
+  "published" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.published')) VIRTUAL,
+  "updated" TEXT GENERATED ALWAYS AS (coalesce(json_extract(raw, '$.updated'), json_extract(raw, '$.deleted'), json_extract(raw, '$.published'))) VIRTUAL,
+  "url" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.url')) VIRTUAL,
+  "actor" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.actor')) VIRTUAL CONSTRAINT activities_actors_iri_fk REFERENCES actors (iri),
+  "object" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.object')) VIRTUAL CONSTRAINT activities_objects_iri_fk REFERENCES objects (iri)
+) STRICT;
+CREATE INDEX IF NOT EXISTS activities_type ON activities(type);
+CREATE INDEX IF NOT EXISTS activities_actor ON activities(actor);
+CREATE INDEX IF NOT EXISTS activities_object ON activities(object);
+CREATE INDEX IF NOT EXISTS activities_published ON activities(published);
+CREATE INDEX IF NOT EXISTS activities_updated ON activities(updated);
+
+CREATE TABLE IF NOT EXISTS actors (
+  "raw" TEXT,
+  "iri" TEXT NOT NULL constraint actors_key unique,
+  "id" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.id')) VIRTUAL,
+  "type" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.type')) VIRTUAL,
+  "to" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.to')) VIRTUAL,
+  "bto" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.bto')) VIRTUAL,
+  "cc" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.cc')) VIRTUAL,
+  "bcc" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.bcc')) VIRTUAL,
+  "published" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.published')) VIRTUAL,
+  "updated" TEXT GENERATED ALWAYS AS (coalesce(json_extract(raw, '$.updated'), json_extract(raw, '$.deleted'), json_extract(raw, '$.published'))) VIRTUAL,
+  "url" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.url')) VIRTUAL,
+  "name" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.name')) VIRTUAL,
+  "preferred_username" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.preferredUsername')) VIRTUAL
+) STRICT;
+CREATE INDEX IF NOT EXISTS actors_type ON actors(type);
+CREATE INDEX IF NOT EXISTS actors_name ON actors(name, preferred_username);
+CREATE INDEX IF NOT EXISTS actors_published ON actors(published);
+CREATE INDEX IF NOT EXISTS actors_updated ON actors(updated);
+
+CREATE TABLE IF NOT EXISTS collections (
+  "raw" TEXT,
+  "iri" TEXT NOT NULL constraint collections_key unique,
+  "id" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.id')) VIRTUAL,
+  "type" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.type')) VIRTUAL,
+  "to" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.to')) VIRTUAL,
+  "bto" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.bto')) VIRTUAL,
+  "cc" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.cc')) VIRTUAL,
+  "bcc" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.bcc')) VIRTUAL,
+  "published" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.published')) VIRTUAL,
+  "updated" TEXT GENERATED ALWAYS AS (json_extract(raw, '$.updated')) VIRTUAL
+) STRICT;
+CREATE INDEX IF NOT EXISTS collections_type ON collections(type);
+CREATE INDEX IF NOT EXISTS collections_published ON collections(published);
+CREATE INDEX IF NOT EXISTS collections_updated ON collections(updated);
+
+CREATE TABLE IF NOT EXISTS meta (
+  "iri" TEXT NOT NULL constraint meta_key unique,
+  "raw" TEXT,
+  "published" TEXT default CURRENT_TIMESTAMP
+) STRICT;
+CREATE TABLE IF NOT EXISTS "clients"(
+	"code" varchar constraint client_code_pkey PRIMARY KEY,
+	"secret" varchar NOT NULL,
+	"redirect_uri" varchar NOT NULL,
+	"extra" TEXT DEFAULT NULL
+);
+CREATE TABLE IF NOT EXISTS "authorize" (
+	"client" varchar REFERENCES clients(code),
+	"code" varchar constraint authorize_code_pkey PRIMARY KEY,
+	"expires_in" INTEGER,
+	"scope" TEXT,
+	"redirect_uri" varchar NOT NULL,
+	"state" TEXT,
+	"code_challenge" varchar DEFAULT NULL,
+	"code_challenge_method" varchar DEFAULT NULL,
+	"created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+	"extra" TEXT DEFAULT '{}'
+);
+CREATE TABLE IF NOT EXISTS "access" (
+	"client" varchar REFERENCES clients(code),
+	"authorize" varchar REFERENCES authorize(code),
+	"previous" varchar,
+	"token" varchar NOT NULL,
+	"refresh_token" varchar NOT NULL,
+	"expires_in" INTEGER,
+	"scope" TEXT DEFAULT NULL,
+	"redirect_uri" varchar NOT NULL,
+	"created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+	"extra" TEXT DEFAULT '{}'
+);
+CREATE TABLE IF NOT EXISTS "refresh" (
+	"access_token" TEXT NOT NULL REFERENCES access(token),
+	"token" TEXT PRIMARY KEY NOT NULL
+);
+`
+
+// schemaVersion returns the version of the latest migration known to the package.
+func schemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+func hasColumn(table, column string) func(ctx context.Context, conn querier) (bool, error) {
+	return func(ctx context.Context, conn querier) (bool, error) {
+		var count int
+		sel := "SELECT count(*) FROM pragma_table_info(?) WHERE name = ?;"
+		if err := conn.QueryRowContext(ctx, sel, table, column).Scan(&count); err != nil {
+			return false, wrapSQLError(err, "unable to load %s table information", table)
+		}
+		return count > 0, nil
+	}
+}
+
+// hasModule checks if the virtual table module name is available in the SQLite library of the driver.
+func hasModule(name string) func(ctx context.Context, conn querier) (bool, error) {
+	return func(ctx context.Context, conn querier) (bool, error) {
+		var count int
+		sel := "SELECT count(*) FROM pragma_module_list WHERE name = ?;"
+		if err := conn.QueryRowContext(ctx, sel, name).Scan(&count); err != nil {
+			return false, wrapSQLError(err, "unable to load the list of modules")
+		}
+		return count > 0, nil
+	}
+}
+
+func loadSchemaVersion(ctx context.Context, conn querier) (int, error) {
+	var version int
+	if err := conn.QueryRowContext(ctx, "PRAGMA user_version;").Scan(&version); err != nil {
+		return 0, wrapSQLError(err, "unable to load schema version")
+	}
+	return version, nil
+}
+
+// migrate applies in order all the migrations newer than the current version of the database.
+// Every migration runs in its own transaction, together with the update of the database version.
+func (r *repo) migrate(ctx context.Context, dryRun bool) error {
+	current, err := loadSchemaVersion(ctx, r.conn)
+	if err != nil {
+		return err
+	}
+	if current > schemaVersion() {
+		return errors.Newf("database schema version %d is newer than the supported version %d", current, schemaVersion())
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if dryRun {
+			if err = r.printMigration(ctx, m); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = r.applyMigration(ctx, m); err != nil {
+			return err
+		}
+		r.logFn("applied migration %d: %s", m.version, m.name)
+	}
+	return nil
+}
+
+func (r *repo) printMigration(ctx context.Context, m migration) error {
+	if m.when != nil {
+		needed, err := m.when(ctx, r.conn)
+		if err != nil {
+			return err
+		}
+		if !needed {
+			r.logFn("-- migration %d: %s (nothing to do)", m.version, m.name)
+			return nil
+		}
+	}
+	r.logFn("-- migration %d: %s\n%s", m.version, m.name, m.query)
+	if m.fn != nil {
+		r.logFn("-- migration %d: followed by changes to the data that are done in Go", m.version)
+	}
+	return nil
+}
+
+func (r *repo) applyMigration(ctx context.Context, m migration) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return wrapSQLError(err, "transaction start error")
+	}
+
+	needed := true
+	if m.when != nil {
+		if needed, err = m.when(ctx, tx); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	if needed {
+		if _, err = tx.ExecContext(ctx, m.query); err != nil {
+			_ = tx.Rollback()
+			return wrapSQLError(err, `unable to execute: "%s"`, stringClean(m.query))
+		}
+		if m.fn != nil {
+			if err = m.fn(ctx, tx); err != nil {
+				_ = tx.Rollback()
+				return errors.Annotatef(err, "unable to apply migration %d", m.version)
+			}
+		}
+	}
+	// NOTE(marius): pragma statements don't support parameters
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d;", m.version)); err != nil {
+		_ = tx.Rollback()
+		return wrapSQLError(err, "unable to update schema version to %d", m.version)
+	}
+	if err = tx.Commit(); err != nil {
+		return wrapSQLError(err, "transaction commit error")
+	}
+	return nil
+}
+
+// checkSchemaVersion verifies that the database has the current schema version.
+// If it is behind, depending on the autoMigrate setting, it either applies the pending migrations, or returns an error.
+func (r *repo) checkSchemaVersion(ctx context.Context) error {
+	current, err := loadSchemaVersion(ctx, r.conn)
+	if err != nil {
+		return err
+	}
+	switch {
+	case current == schemaVersion():
+		return nil
+	case current > schemaVersion():
+		return errors.Newf("database schema version %d is newer than the supported version %d", current, schemaVersion())
+	case r.autoMigrate:
+		return r.migrate(ctx, false)
+	default:
+		return errors.Newf("database schema version %d is behind the current version %d, it needs to be migrated", current, schemaVersion())
+	}
+}
@@ -0,0 +1,128 @@
+package sqlite
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+func withSchemaVersion(version int) initFn {
+	return func(t *testing.T, r *repo) *repo {
+		if _, err := r.conn.Exec("PRAGMA user_version = " + strconv.Itoa(version) + ";"); err != nil {
+			t.Errorf("unable to set schema version %d: %s", version, err)
+		}
+		return r
+	}
+}
+
+func Test_repo_migrate(t *testing.T) {
+	tests := []struct {
+		name        string
+		setupFns    []initFn
+		dryRun      bool
+		wantVersion int
+		wantErr     error
+	}{
+		{
+			name:        "empty database",
+			setupFns:    []initFn{withOpenRoot},
+			wantVersion: schemaVersion(),
+		},
+		{
+			name:        "dry run does not change the version",
+			setupFns:    []initFn{withOpenRoot},
+			dryRun:      true,
+			wantVersion: 0,
+		},
+		{
+			name:        "already migrated",
+			setupFns:    []initFn{withOpenRoot, withBootstrap},
+			wantVersion: schemaVersion(),
+		},
+		{
+			name:        "newer than supported",
+			setupFns:    []initFn{withOpenRoot, withSchemaVersion(schemaVersion() + 1)},
+			wantVersion: schemaVersion() + 1,
+			wantErr:     errors.Newf("database schema version %d is newer than the supported version %d", schemaVersion()+1, schemaVersion()),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mockRepo(t, fields{path: t.TempDir()}, tt.setupFns...)
+			t.Cleanup(r.Close)
+
+			err := r.migrate(context.Background(), tt.dryRun)
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Errorf("migrate() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+			}
+
+			version, err := loadSchemaVersion(context.Background(), r.conn)
+			be.NilErr(t, err)
+			be.Equal(t, tt.wantVersion, version)
+		})
+	}
+}
+
+func Test_hasModule(t *testing.T) {
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot)
+	t.Cleanup(r.Close)
+
+	ok, err := hasModule("json_each")(context.Background(), r.conn)
+	be.NilErr(t, err)
+	be.True(t, ok)
+
+	ok, err = hasModule("missing")(context.Background(), r.conn)
+	be.NilErr(t, err)
+	be.False(t, ok)
+}
+
+func Test_repo_Open_schemaVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		bootstrap   bool
+		autoMigrate bool
+		wantErr     error
+	}{
+		{
+			name:      "bootstrapped",
+			bootstrap: true,
+		},
+		{
+			name:    "behind",
+			wantErr: errors.Newf("database schema version %d is behind the current version %d, it needs to be migrated", 0, schemaVersion()),
+		},
+		{
+			name:        "behind with auto migrate",
+			autoMigrate: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := Config{Path: t.TempDir(), AutoMigrate: tt.autoMigrate, LogFn: t.Logf, ErrFn: t.Errorf}
+			if tt.bootstrap {
+				be.NilErr(t, Bootstrap(conf))
+			}
+			r, err := New(conf)
+			be.NilErr(t, err)
+
+			err = r.Open()
+			t.Cleanup(r.Close)
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Errorf("Open() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+			}
+			if tt.wantErr != nil {
+				if r.conn != nil {
+					t.Errorf("Open() left the connection open after failing")
+				}
+				return
+			}
+			version, err := loadSchemaVersion(context.Background(), r.conn)
+			be.NilErr(t, err)
+			be.Equal(t, schemaVersion(), version)
+		})
+	}
+}
@@ -2,41 +2,4 @@
 
 package sqlite
 
-import (
-	"database/sql"
-	"net/url"
-	"strconv"
-
-	_ "modernc.org/sqlite"
-)
-
-var defaultQueryParam = url.Values{
-	"_txlock": []string{"immediate"},
-	"_pragma": []string{
-		"synchronous=NORMAL",
-		"cache_size=-64000",
-		"journal_mode=WAL",
-		"busy_timeout=" + strconv.Itoa(int(2*defaultTimeout.Milliseconds())),
-		"wal_autocheckpoint=0",
-		"strict=1",
-	},
-}
-
-type sqlErr struct {
-	msg  string
-	code int
-}
-
-func (e sqlErr) Error() string {
-	return e.msg
-}
-
-var errCantOpen = &sqlErr{msg: "unable to open database file (14)", code: 14}
-
-var errNoSuchTable = &sqlErr{msg: "SQL logic error: no such table: activities (1)", code: 1}
-
-// sqlOpen will use the learnc.org/sqlite when compiled without CGO
-// this driver is less performant.
-var sqlOpen = func(dataSourceName string) (*sql.DB, error) {
-	return sql.Open("sqlite", dataSourceName+"?"+defaultQueryParam.Encode())
-}
+const defaultDriver = DriverModernc
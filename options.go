@@ -0,0 +1,107 @@
+package sqlite
+
+import (
+	"maps"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-ap/errors"
+)
+
+// ConnectionOptions are the settings for the connections to the database.
+// The zero value of every field means that the package default is used.
+// The mattn driver sets the pragmas using the parameters of the data source name, and it returns an error for
+// the ones it doesn't support, like mmap_size or temp_store.
+type ConnectionOptions struct {
+	// ReadPoolSize is the maximum number of connections used for reading.
+	// It defaults to the number of CPUs, but not less than two. The writes always use a single connection.
+	ReadPoolSize int
+	// ConnMaxLifetime is the maximum amount of time a connection may be reused.
+	ConnMaxLifetime time.Duration
+	// ConnMaxIdleTime is the maximum amount of time a connection may be idle before being closed.
+	ConnMaxIdleTime time.Duration
+	// BusyTimeout is how long a connection waits for a lock held by another one, before failing.
+	BusyTimeout time.Duration
+	// Synchronous is the value for the "synchronous" pragma: OFF, NORMAL, FULL or EXTRA.
+	// It defaults to NORMAL.
+	Synchronous string
+	// MMapSize is the maximum number of bytes of the database file that are memory mapped.
+	MMapSize int64
+	// CacheSize is the value for the "cache_size" pragma: a positive value is a number of pages,
+	// and a negative one is a number of kibibytes. It defaults to -64000.
+	CacheSize int
+	// TempStore is the value for the "temp_store" pragma: DEFAULT, FILE or MEMORY.
+	TempStore string
+	// Pragmas are extra pragmas that get set on every connection.
+	Pragmas map[string]string
+
+	// readOnly opens the database without changing its journal mode, and doesn't allow any writes to it.
+	readOnly bool
+}
+
+const (
+	defaultSynchronous = "NORMAL"
+	defaultCacheSize   = -64000
+)
+
+func (o ConnectionOptions) readPoolSize() int {
+	if o.ReadPoolSize > 0 {
+		return o.ReadPoolSize
+	}
+	return max(2, runtime.NumCPU())
+}
+
+type pragma struct {
+	name  string
+	value string
+}
+
+var (
+	validPragmaName  = regexp.MustCompile(`^[a-z_]+$`)
+	validPragmaValue = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// pragmas returns the pragmas that need to be set on every connection, in the order they need to be applied.
+// As the pragma statements don't support parameters, the names and values are validated.
+func (o ConnectionOptions) pragmas() ([]pragma, error) {
+	busyTimeout := 2 * defaultTimeout
+	if o.BusyTimeout > 0 {
+		busyTimeout = o.BusyTimeout
+	}
+	synchronous := defaultSynchronous
+	if o.Synchronous != "" {
+		synchronous = o.Synchronous
+	}
+	cacheSize := defaultCacheSize
+	if o.CacheSize != 0 {
+		cacheSize = o.CacheSize
+	}
+
+	pp := []pragma{
+		{name: "busy_timeout", value: strconv.FormatInt(busyTimeout.Milliseconds(), 10)},
+		{name: "synchronous", value: synchronous},
+		{name: "cache_size", value: strconv.Itoa(cacheSize)},
+	}
+	if o.MMapSize > 0 {
+		pp = append(pp, pragma{name: "mmap_size", value: strconv.FormatInt(o.MMapSize, 10)})
+	}
+	if o.TempStore != "" {
+		pp = append(pp, pragma{name: "temp_store", value: o.TempStore})
+	}
+	for _, name := range slices.Sorted(maps.Keys(o.Pragmas)) {
+		pp = append(pp, pragma{name: name, value: o.Pragmas[name]})
+	}
+
+	for _, p := range pp {
+		if !validPragmaName.MatchString(p.name) {
+			return nil, errors.Newf("invalid pragma name %q", p.name)
+		}
+		if !validPragmaValue.MatchString(p.value) {
+			return nil, errors.Newf("invalid value %q for pragma %s", p.value, p.name)
+		}
+	}
+	return pp, nil
+}
@@ -0,0 +1,133 @@
+package sqlite
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestConnectionOptions_pragmas(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    ConnectionOptions
+		want    []pragma
+		wantErr error
+	}{
+		{
+			name: "defaults",
+			want: []pragma{
+				{name: "busy_timeout", value: "2000"},
+				{name: "synchronous", value: "NORMAL"},
+				{name: "cache_size", value: "-64000"},
+			},
+		},
+		{
+			name: "all",
+			opts: ConnectionOptions{
+				BusyTimeout: 5 * time.Second,
+				Synchronous: "FULL",
+				MMapSize:    1 << 28,
+				CacheSize:   -2000,
+				TempStore:   "MEMORY",
+				Pragmas:     map[string]string{"foreign_keys": "1", "cell_size_check": "ON"},
+			},
+			want: []pragma{
+				{name: "busy_timeout", value: "5000"},
+				{name: "synchronous", value: "FULL"},
+				{name: "cache_size", value: "-2000"},
+				{name: "mmap_size", value: "268435456"},
+				{name: "temp_store", value: "MEMORY"},
+				{name: "cell_size_check", value: "ON"},
+				{name: "foreign_keys", value: "1"},
+			},
+		},
+		{
+			name:    "invalid value",
+			opts:    ConnectionOptions{Synchronous: "OFF; DROP TABLE objects"},
+			wantErr: errors.Newf("invalid value %q for pragma %s", "OFF; DROP TABLE objects", "synchronous"),
+		},
+		{
+			name:    "invalid name",
+			opts:    ConnectionOptions{Pragmas: map[string]string{"user_version = 1; --": "1"}},
+			wantErr: errors.Newf("invalid pragma name %q", "user_version = 1; --"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.opts.pragmas()
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Errorf("pragmas() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+				return
+			}
+			if !cmp.Equal(got, tt.want, cmp.AllowUnexported(pragma{})) {
+				t.Errorf("pragmas() = %s", cmp.Diff(tt.want, got, cmp.AllowUnexported(pragma{})))
+			}
+		})
+	}
+}
+
+func Test_repo_Open_connectionOptions(t *testing.T) {
+	for _, driver := range Drivers() {
+		t.Run(driver, func(t *testing.T) {
+			opts := ConnectionOptions{
+				ReadPoolSize: 3,
+				CacheSize:    -2000,
+				Pragmas:      map[string]string{"foreign_keys": "1"},
+			}
+			pragmas := map[string]int{
+				"cache_size":   -2000,
+				"foreign_keys": 1,
+				"busy_timeout": 2000,
+			}
+			if driver != DriverMattn {
+				// NOTE(marius): the pragmas from the options need to override the defaults of the driver
+				opts.TempStore = "MEMORY"
+				opts.Pragmas["wal_autocheckpoint"] = "500"
+				pragmas["temp_store"] = 2
+				pragmas["wal_autocheckpoint"] = 500
+			}
+			conf := Config{
+				Path:       t.TempDir(),
+				Driver:     driver,
+				LogFn:      t.Logf,
+				ErrFn:      t.Errorf,
+				Connection: opts,
+			}
+			be.NilErr(t, Bootstrap(conf))
+
+			r, err := New(conf)
+			be.NilErr(t, err)
+			be.NilErr(t, r.Open())
+			t.Cleanup(r.Close)
+
+			be.Equal(t, 1, r.conn.Stats().MaxOpenConnections)
+			be.Equal(t, 3, r.ro.Stats().MaxOpenConnections)
+
+			for name, want := range pragmas {
+				var got int
+				be.NilErr(t, r.ro.QueryRow("PRAGMA "+name+";").Scan(&got))
+				be.Equal(t, want, got)
+			}
+
+			var journal string
+			be.NilErr(t, r.ro.QueryRow("PRAGMA journal_mode;").Scan(&journal))
+			be.Equal(t, "wal", journal)
+		})
+	}
+}
+
+func Test_mattnOpen_unsupportedPragma(t *testing.T) {
+	d, err := getDriver(DriverMattn)
+	if err != nil {
+		t.Skip("the mattn driver is available only in CGO builds")
+	}
+	_, err = d.open(filepath.Join(t.TempDir(), "storage.sqlite"), ConnectionOptions{TempStore: "MEMORY"})
+	wantErr := errors.NotImplementedf("pragma %s is not supported by the %s driver", "temp_store", DriverMattn)
+	if !cmp.Equal(err, wantErr, EquateWeakErrors) {
+		t.Errorf("open() error = %s", cmp.Diff(wantErr, err, EquateWeakErrors))
+	}
+}
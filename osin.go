@@ -15,50 +15,6 @@ import (
 
 const defaultTimeout = 1000 * time.Millisecond
 
-const (
-	createClientTable = `CREATE TABLE IF NOT EXISTS "clients"(
-	"code" varchar constraint client_code_pkey PRIMARY KEY,
-	"secret" varchar NOT NULL,
-	"redirect_uri" varchar NOT NULL,
-	"extra" TEXT DEFAULT NULL
-);
-`
-
-	createAuthorizeTable = `CREATE TABLE IF NOT EXISTS "authorize" (
-	"client" varchar REFERENCES clients(code),
-	"code" varchar constraint authorize_code_pkey PRIMARY KEY,
-	"expires_in" INTEGER,
-	"scope" TEXT,
-	"redirect_uri" varchar NOT NULL,
-	"state" TEXT,
-	"code_challenge" varchar DEFAULT NULL,
-	"code_challenge_method" varchar DEFAULT NULL,
-	"created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
-	"extra" TEXT DEFAULT '{}'
-);
-`
-
-	createAccessTable = `CREATE TABLE IF NOT EXISTS "access" (
-	"client" varchar REFERENCES clients(code),
-	"authorize" varchar REFERENCES authorize(code),
-	"previous" varchar,
-	"token" varchar NOT NULL,
-	"refresh_token" varchar NOT NULL,
-	"expires_in" INTEGER,
-	"scope" TEXT DEFAULT NULL,
-	"redirect_uri" varchar NOT NULL,
-	"created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
-	"extra" TEXT DEFAULT '{}'
-);
-`
-
-	createRefreshTable = `CREATE TABLE IF NOT EXISTS "refresh" (
-	"access_token" TEXT NOT NULL REFERENCES access(token),
-	"token" TEXT PRIMARY KEY NOT NULL
-);
-`
-)
-
 var encodeFn = func(v any) ([]byte, error) {
 	buf := bytes.Buffer{}
 	err := json.NewEncoder(&buf).Encode(v)
@@ -85,7 +41,13 @@ func (r *repo) Clone() osin.Storage {
 
 // Close
 func (r *repo) Close() {
+	r.stopRetentionWorker()
+	r.stopCheckpointer()
+	r.stopCacheInvalidator()
 	if r.conn != nil {
+		if err := r.Checkpoint(context.Background(), CheckpointTruncate); err != nil {
+			r.errFn("final checkpoint err: %+s", err)
+		}
 		if err := r.conn.Close(); err != nil {
 			r.errFn("write connection close err: %+s", err)
 		}
@@ -103,22 +65,27 @@ const getClients = "SELECT code, secret, redirect_uri, extra FROM clients;"
 
 // ListClients
 func (r *repo) ListClients() ([]osin.Client, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancelFn()
+
+	return r.ListClientsContext(ctx)
+}
+
+// ListClientsContext
+func (r *repo) ListClientsContext(ctx context.Context) ([]osin.Client, error) {
 	if r == nil || r.ro == nil {
 		return nil, errNotOpen
 	}
 
 	result := make([]osin.Client, 0)
 
-	ctx, cancelFn := context.WithTimeout(context.Background(), defaultTimeout)
-	defer cancelFn()
-
 	rows, err := r.ro.QueryContext(ctx, getClients)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, errors.NewNotFound(err, "No clients found")
 		}
 		r.errFn("Error listing clients: %+s", err)
-		return result, errors.Annotatef(err, "Unable to load clients")
+		return result, wrapSQLError(err, "Unable to load clients")
 	}
 	defer rows.Close()
 
@@ -178,7 +145,7 @@ func getClient(conn *sql.DB, ctx context.Context, code string) (osin.Client, err
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, errClientNotFound(err)
 		}
-		return nil, errors.Annotatef(err, "Unable to load client")
+		return nil, wrapSQLError(err, "Unable to load client")
 	}
 
 	c := new(cl)
@@ -187,7 +154,7 @@ func getClient(conn *sql.DB, ctx context.Context, code string) (osin.Client, err
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, errClientNotFound(err)
 		}
-		return nil, errors.Annotatef(err, "Unable to load client information")
+		return nil, wrapSQLError(err, "Unable to load client information")
 	}
 
 	if userData.Valid {
@@ -198,6 +165,14 @@ func getClient(conn *sql.DB, ctx context.Context, code string) (osin.Client, err
 
 // GetClient
 func (r *repo) GetClient(code string) (osin.Client, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancelFn()
+
+	return r.GetClientContext(ctx, code)
+}
+
+// GetClientContext
+func (r *repo) GetClientContext(ctx context.Context, code string) (osin.Client, error) {
 	if r == nil || r.ro == nil {
 		return nil, errNotOpen
 	}
@@ -205,9 +180,6 @@ func (r *repo) GetClient(code string) (osin.Client, error) {
 		return nil, errors.NotFoundf("Empty client code")
 	}
 
-	ctx, cancelFn := context.WithTimeout(context.Background(), defaultTimeout)
-	defer cancelFn()
-
 	return getClient(r.ro, ctx, code)
 }
 
@@ -219,6 +191,14 @@ const createClient = "INSERT OR REPLACE INTO clients (code, secret, redirect_uri
 
 // SaveClient
 func (r *repo) SaveClient(c osin.Client) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancelFn()
+
+	return r.SaveClientContext(ctx, c)
+}
+
+// SaveClientContext
+func (r *repo) SaveClientContext(ctx context.Context, c osin.Client) error {
 	if r == nil || r.conn == nil {
 		return errNotOpen
 	}
@@ -238,10 +218,9 @@ func (r *repo) SaveClient(c osin.Client) error {
 		data,
 	}
 
-	ctx, _ := context.WithTimeout(context.Background(), defaultTimeout)
-	if _, err = r.conn.ExecContext(ctx, createClient, params...); err != nil {
+	if _, err = r.exec(ctx, createClient, params...); err != nil {
 		r.errFn("Error inserting client id %s: %+s", c.GetId(), err)
-		return errors.Annotatef(err, "Unable to save new client")
+		return wrapSQLError(err, "Unable to save new client")
 	}
 	return nil
 }
@@ -250,13 +229,20 @@ const removeClient = "DELETE FROM clients WHERE code=?"
 
 // RemoveClient
 func (r *repo) RemoveClient(id string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancelFn()
+
+	return r.RemoveClientContext(ctx, id)
+}
+
+// RemoveClientContext
+func (r *repo) RemoveClientContext(ctx context.Context, id string) error {
 	if r == nil || r.conn == nil {
 		return errNotOpen
 	}
-	ctx, _ := context.WithTimeout(context.Background(), defaultTimeout)
-	if _, err := r.conn.ExecContext(ctx, removeClient, id); err != nil {
+	if _, err := r.exec(ctx, removeClient, id); err != nil {
 		r.errFn("Failed deleting client id %s: %+s", id, err)
-		return errors.Annotatef(err, "Unable to remove client")
+		return wrapSQLError(err, "Unable to remove client")
 	}
 	r.logFn("Successfully removed client %s", id)
 	return nil
@@ -268,6 +254,14 @@ code_challenge, code_challenge_method)
 
 // SaveAuthorize saves authorize data.
 func (r *repo) SaveAuthorize(data *osin.AuthorizeData) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancelFn()
+
+	return r.SaveAuthorizeContext(ctx, data)
+}
+
+// SaveAuthorizeContext saves authorize data.
+func (r *repo) SaveAuthorizeContext(ctx context.Context, data *osin.AuthorizeData) error {
 	if r == nil || r.conn == nil {
 		return errNotOpen
 	}
@@ -297,12 +291,9 @@ func (r *repo) SaveAuthorize(data *osin.AuthorizeData) error {
 		params = append(params, nil, nil)
 	}
 
-	ctx, cancelFn := context.WithTimeout(context.Background(), defaultTimeout)
-	defer cancelFn()
-
-	if _, err = r.conn.ExecContext(ctx, saveAuthorize, params...); err != nil {
+	if _, err = r.exec(ctx, saveAuthorize, params...); err != nil {
 		r.errFn("Failed to insert authorize data for client id %s, code %s: %+s", data.Client.GetId(), data.Code, err)
-		return errors.Annotatef(err, "Unable to save authorize token")
+		return wrapSQLError(err, "Unable to save authorize token")
 	}
 	return nil
 }
@@ -323,7 +314,7 @@ func loadAuthorize(conn *sql.DB, ctx context.Context, code string) (*osin.Author
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, errors.NotFoundf("Unable to load authorize token")
 		}
-		return nil, errors.Annotatef(err, "Unable to load authorize token")
+		return nil, wrapSQLError(err, "Unable to load authorize token")
 	}
 	defer rows.Close()
 
@@ -344,7 +335,7 @@ func loadAuthorize(conn *sql.DB, ctx context.Context, code string) (*osin.Author
 			&aCodeChallenge, &aCodeChallengeMethod,
 			&c.Id, &c.RedirectUri, &c.Secret, &cUserData)
 		if err != nil {
-			return nil, errors.Annotatef(err, "unable to load authorize data")
+			return nil, wrapSQLError(err, "unable to load authorize data")
 		}
 
 		a.CreatedAt, _ = time.Parse(time.RFC3339Nano, createdAt)
@@ -377,6 +368,14 @@ func loadAuthorize(conn *sql.DB, ctx context.Context, code string) (*osin.Author
 
 // LoadAuthorize looks up AuthorizeData by a code.
 func (r *repo) LoadAuthorize(code string) (*osin.AuthorizeData, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancelFn()
+
+	return r.LoadAuthorizeContext(ctx, code)
+}
+
+// LoadAuthorizeContext looks up AuthorizeData by a code.
+func (r *repo) LoadAuthorizeContext(ctx context.Context, code string) (*osin.AuthorizeData, error) {
 	if r == nil || r.ro == nil {
 		return nil, errNotOpen
 	}
@@ -384,9 +383,6 @@ func (r *repo) LoadAuthorize(code string) (*osin.AuthorizeData, error) {
 		return nil, errors.Newf("Empty authorize code")
 	}
 
-	ctx, cancelFn := context.WithTimeout(context.Background(), defaultTimeout)
-	defer cancelFn()
-
 	return loadAuthorize(r.ro, ctx, code)
 }
 
@@ -394,13 +390,20 @@ const removeAuthorize = "DELETE FROM authorize WHERE code=?"
 
 // RemoveAuthorize revokes or deletes the authorization code.
 func (r *repo) RemoveAuthorize(code string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancelFn()
+
+	return r.RemoveAuthorizeContext(ctx, code)
+}
+
+// RemoveAuthorizeContext revokes or deletes the authorization code.
+func (r *repo) RemoveAuthorizeContext(ctx context.Context, code string) error {
 	if r == nil || r.conn == nil {
 		return errNotOpen
 	}
-	ctx, _ := context.WithTimeout(context.Background(), defaultTimeout)
-	if _, err := r.conn.ExecContext(ctx, removeAuthorize, code); err != nil {
+	if _, err := r.exec(ctx, removeAuthorize, code); err != nil {
 		r.errFn("Failed deleting authorize data code %s: %+s", code, err)
-		return errors.Annotatef(err, "Unable to delete authorize token")
+		return wrapSQLError(err, "Unable to delete authorize token")
 	}
 	r.logFn("Successfully removed authorization token %s", code)
 	return nil
@@ -411,6 +414,14 @@ const saveAccess = `INSERT INTO access (client, authorize, previous, token, refr
 
 // SaveAccess writes AccessData.
 func (r *repo) SaveAccess(data *osin.AccessData) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancelFn()
+
+	return r.SaveAccessContext(ctx, data)
+}
+
+// SaveAccessContext writes AccessData.
+func (r *repo) SaveAccessContext(ctx context.Context, data *osin.AccessData) error {
 	if r == nil || r.conn == nil {
 		return errNotOpen
 	}
@@ -431,9 +442,6 @@ func (r *repo) SaveAccess(data *osin.AccessData) error {
 		return err
 	}
 
-	ctx, cancelFn := context.WithTimeout(context.TODO(), defaultTimeout)
-	defer cancelFn()
-
 	params := []interface{}{
 		data.Client.GetId(),
 		authorizeData.Code,
@@ -451,10 +459,10 @@ func (r *repo) SaveAccess(data *osin.AccessData) error {
 		return errors.Newf("data.Client must not be nil")
 	}
 
-	_, err = r.conn.ExecContext(ctx, saveAccess, params...)
+	_, err = r.exec(ctx, saveAccess, params...)
 	if err != nil {
 		r.errFn("Failed saving access data for client id %s: %+s", data.Client.GetId(), err)
-		return errors.Annotatef(err, "Unable to create access token")
+		return wrapSQLError(err, "Unable to create access token")
 	}
 	if len(data.RefreshToken) > 0 {
 		if err = r.saveRefresh(ctx, data.RefreshToken, data.AccessToken); err != nil {
@@ -482,7 +490,7 @@ func loadAccess(conn *sql.DB, ctx context.Context, code string, loadDeps bool) (
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, errors.NewNotFound(err, "Unable to load access token")
 		}
-		return nil, errors.Annotatef(err, "Unable to load access token")
+		return nil, wrapSQLError(err, "Unable to load access token")
 	}
 	defer rows.Close()
 
@@ -508,7 +516,7 @@ func loadAccess(conn *sql.DB, ctx context.Context, code string, loadDeps bool) (
 			if errors.Is(err, sql.ErrNoRows) {
 				return nil, errors.NewNotFound(err, "Unable to load access data")
 			}
-			return nil, errors.Annotatef(err, "unable to load access data")
+			return nil, wrapSQLError(err, "unable to load access data")
 		}
 
 		acc.CreatedAt, _ = time.Parse(time.RFC3339Nano, accCreatedAt)
@@ -581,6 +589,14 @@ var ReadOnlyTxn = sql.TxOptions{
 
 // LoadAccess retrieves access data by token. Client information MUST be loaded together.
 func (r *repo) LoadAccess(code string) (*osin.AccessData, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancelFn()
+
+	return r.LoadAccessContext(ctx, code)
+}
+
+// LoadAccessContext retrieves access data by token. Client information MUST be loaded together.
+func (r *repo) LoadAccessContext(ctx context.Context, code string) (*osin.AccessData, error) {
 	if r == nil || r.ro == nil {
 		return nil, errNotOpen
 	}
@@ -588,9 +604,6 @@ func (r *repo) LoadAccess(code string) (*osin.AccessData, error) {
 		return nil, errors.Newf("Empty access code")
 	}
 
-	ctx, cancelFn := context.WithTimeout(context.Background(), defaultTimeout)
-	defer cancelFn()
-
 	return loadAccess(r.ro, ctx, code, true)
 }
 
@@ -598,14 +611,21 @@ const removeAccess = "DELETE FROM access WHERE token=?"
 
 // RemoveAccess revokes or deletes an AccessData.
 func (r *repo) RemoveAccess(code string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancelFn()
+
+	return r.RemoveAccessContext(ctx, code)
+}
+
+// RemoveAccessContext revokes or deletes an AccessData.
+func (r *repo) RemoveAccessContext(ctx context.Context, code string) error {
 	if r == nil || r.conn == nil {
 		return errNotOpen
 	}
-	ctx, _ := context.WithTimeout(context.Background(), defaultTimeout)
-	_, err := r.conn.ExecContext(ctx, removeAccess, code)
+	_, err := r.exec(ctx, removeAccess, code)
 	if err != nil {
 		r.errFn("Failed removing access code %s: %+s", code, err)
-		return errors.Annotatef(err, "Unable to remove access token")
+		return wrapSQLError(err, "Unable to remove access token")
 	}
 	r.logFn("Successfully removed access token %s", code)
 	return nil
@@ -615,6 +635,14 @@ const loadRefresh = "SELECT access_token FROM refresh WHERE token=? LIMIT 1"
 
 // LoadRefresh retrieves refresh AccessData. Client information MUST be loaded together.
 func (r *repo) LoadRefresh(code string) (*osin.AccessData, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancelFn()
+
+	return r.LoadRefreshContext(ctx, code)
+}
+
+// LoadRefreshContext retrieves refresh AccessData. Client information MUST be loaded together.
+func (r *repo) LoadRefreshContext(ctx context.Context, code string) (*osin.AccessData, error) {
 	if r == nil || r.ro == nil {
 		return nil, errNotOpen
 	}
@@ -622,16 +650,13 @@ func (r *repo) LoadRefresh(code string) (*osin.AccessData, error) {
 		return nil, errors.Newf("Empty refresh code")
 	}
 
-	ctx, cancelFn := context.WithTimeout(context.Background(), defaultTimeout)
-	defer cancelFn()
-
 	var access sql.NullString
 	err := r.ro.QueryRowContext(ctx, loadRefresh, code).Scan(&access)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, errors.NewNotFound(err, "Unable to load refresh token")
 		}
-		return nil, errors.Annotatef(err, "Unable to load refresh token")
+		return nil, wrapSQLError(err, "Unable to load refresh token")
 	}
 
 	return loadAccess(r.ro, ctx, access.String, true)
@@ -641,15 +666,21 @@ const removeRefresh = "DELETE FROM refresh WHERE token=?"
 
 // RemoveRefresh revokes or deletes refresh AccessData.
 func (r *repo) RemoveRefresh(code string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancelFn()
+
+	return r.RemoveRefreshContext(ctx, code)
+}
+
+// RemoveRefreshContext revokes or deletes refresh AccessData.
+func (r *repo) RemoveRefreshContext(ctx context.Context, code string) error {
 	if r == nil || r.conn == nil {
 		return errNotOpen
 	}
-	ctx, _ := context.WithTimeout(context.Background(), defaultTimeout)
-
-	_, err := r.conn.ExecContext(ctx, removeRefresh, code)
+	_, err := r.exec(ctx, removeRefresh, code)
 	if err != nil {
 		r.errFn("Failed removing refresh code %s: %+s", code, err)
-		return errors.Annotatef(err, "Unable to remove refresh token")
+		return wrapSQLError(err, "Unable to remove refresh token")
 	}
 	r.logFn("Successfully removed refresh token %s", code)
 	return nil
@@ -658,8 +689,8 @@ func (r *repo) RemoveRefresh(code string) error {
 const saveRefresh = "INSERT OR REPLACE INTO refresh (token, access_token) VALUES (?, ?)"
 
 func (r *repo) saveRefresh(ctx context.Context, refresh, access string) (err error) {
-	if _, err = r.conn.ExecContext(ctx, saveRefresh, refresh, access); err != nil {
-		return errors.Annotatef(err, "Unable to save refresh token")
+	if _, err = r.exec(ctx, saveRefresh, refresh, access); err != nil {
+		return wrapSQLError(err, "Unable to save refresh token")
 	}
 	return nil
 }
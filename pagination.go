@@ -0,0 +1,227 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+	"github.com/leporo/sqlf"
+)
+
+// The query parameters used for keyset pagination of collections.
+// They are distinct from the "after"/"before"/"maxItems" ones used by the filters package,
+// which are applied in memory after the whole collection has been loaded.
+const (
+	pageSizeKey  = "pageSize"
+	afterKeyKey  = "afterKey"
+	beforeKeyKey = "beforeKey"
+)
+
+// cursor is the position of an item in a collection sorted by (published DESC, iri DESC).
+type cursor struct {
+	published string
+	iri       vocab.IRI
+}
+
+func (c cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.published + "\n" + c.iri.String()))
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.NewBadRequest(err, "invalid pagination cursor %q", s)
+	}
+	pub, iri, ok := strings.Cut(string(raw), "\n")
+	if !ok || iri == "" {
+		return nil, errors.BadRequestf("invalid pagination cursor %q", s)
+	}
+	return &cursor{published: pub, iri: vocab.IRI(iri)}, nil
+}
+
+// keysetPage is a request for one page of a collection.
+// At most one of after and before is set, when neither is, it represents the first page.
+type keysetPage struct {
+	size   int
+	after  *cursor
+	before *cursor
+}
+
+// keysetPageFromIRI returns the page requested by the query parameters of the iri, or nil when
+// the iri doesn't contain any keyset pagination parameters.
+func keysetPageFromIRI(iri vocab.IRI) (*keysetPage, error) {
+	u, err := iri.URL()
+	if err != nil {
+		return nil, nil
+	}
+	q := u.Query()
+	if !q.Has(pageSizeKey) && !q.Has(afterKeyKey) && !q.Has(beforeKeyKey) {
+		return nil, nil
+	}
+
+	p := keysetPage{size: filters.MaxItems}
+	if size, err := strconv.Atoi(q.Get(pageSizeKey)); err == nil && size > 0 && size <= filters.MaxItems {
+		p.size = size
+	}
+	if after := q.Get(afterKeyKey); after != "" {
+		if p.after, err = decodeCursor(after); err != nil {
+			return nil, err
+		}
+	}
+	if before := q.Get(beforeKeyKey); before != "" {
+		if p.before, err = decodeCursor(before); err != nil {
+			return nil, err
+		}
+	}
+	if p.after != nil && p.before != nil {
+		return nil, errors.BadRequestf("only one of %s and %s can be used", afterKeyKey, beforeKeyKey)
+	}
+	return &p, nil
+}
+
+func (p keysetPage) iri(col vocab.IRI, key string, c *cursor) vocab.IRI {
+	q := url.Values{}
+	q.Set(pageSizeKey, strconv.Itoa(p.size))
+	if c != nil {
+		q.Set(key, c.String())
+	}
+	return vocab.IRI(col.String() + "?" + q.Encode())
+}
+
+// where adds the boundary of the page to the statement that selects the items of a collection from table,
+// which is the alias of the table holding the items.
+func (p *keysetPage) where(s *sqlf.Stmt, table string) {
+	if p == nil || (p.after == nil && p.before == nil) {
+		return
+	}
+	op, c := "<", p.after
+	if p.before != nil {
+		op, c = ">", p.before
+	}
+	s.Where("(coalesce("+table+".published, ''), "+table+".iri) "+op+" (?, ?)", c.published, c.iri)
+}
+
+// collectionItemsQuery returns the statement that selects the iri, raw value and published date of the items
+// belonging to the collection, which match the filters that can be converted to SQL.
+// When p is not nil, it selects only the items that are past the cursor of the page.
+//
+// Like for threeTablesQuery, the items in the collection_items table are selected from each of the tables
+// in which they can be stored, so the filters apply to the columns of that table.
+func collectionItemsQuery(iri vocab.IRI, p *keysetPage, f ...filters.Check) *sqlf.Stmt {
+	if isStorageCollectionIRI(iri) {
+		s := sqlf.From(string(getCollectionTypeFromIRI(iri)) + " x")
+		s.Select("x.iri iri").Select("x.raw raw").Select("coalesce(x.published, '') published")
+		if isActorsCollectionIRI(iri) {
+			// NOTE(marius): see the comment in loadFromCollectionTable
+			s.Where("x.iri LIKE ?", iri.String()+"%")
+		}
+		_ = filters.SQLWhere(s, f...)
+		p.where(s, "x")
+		return s
+	}
+
+	var unions *sqlf.Stmt
+	for _, table := range []string{"activities", "actors", "objects"} {
+		st := sqlf.From("collection_items ci")
+		st.Select("x.iri iri").Select("x.raw raw").Select("coalesce(x.published, '') published")
+		st.Join(table+" x", "ci.item_iri = x.iri")
+		st.Where("ci.collection_iri = ?", iri)
+		_ = filters.SQLWhere(st, f...)
+		p.where(st, "x")
+		if unions == nil {
+			unions = st
+		} else {
+			unions.Union(true, st)
+		}
+	}
+	return unions
+}
+
+// loadCollectionPage loads a single page of the collection at iri, with the boundaries, the filters and the
+// limit applied in SQL, so only the rows of the page are read from the database.
+// The items are sorted by their published date, the newest first, like in the unpaged collection.
+func loadCollectionPage(r *repo, ctx context.Context, iri vocab.IRI, p keysetPage, f ...filters.Check) (vocab.CollectionInterface, error) {
+	conn := r.reader()
+
+	var raw []byte
+	err := conn.QueryRowContext(ctx, "SELECT raw FROM collections WHERE iri = ?;", iri).Scan(&raw)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.NotFoundf("Unable to find collection %s", iri)
+		}
+		return nil, wrapSQLError(err, "failed to run select for %s", iri)
+	}
+	col := vocab.OrderedCollection{}
+	if err = decodeFn(raw, &col); err != nil {
+		return nil, errors.Annotatef(err, "Collection unmarshal error")
+	}
+
+	items := collectionItemsQuery(iri, &p, f...)
+	s := sqlf.From("("+items.String()+") AS i", items.Args()...)
+	s.Select("i.iri").Select("i.raw").Select("i.published")
+	s.Where("i.raw IS NOT NULL")
+	// NOTE(marius): when going backwards we load the page in reverse order, and we flip it after
+	backwards := p.before != nil
+	if backwards {
+		s.OrderBy("i.published ASC", "i.iri ASC")
+	} else {
+		s.OrderBy("i.published DESC", "i.iri DESC")
+	}
+	// NOTE(marius): we load one extra row to find out if there's a page after this one
+	s.Limit(p.size + 1)
+
+	rows, err := conn.QueryContext(ctx, s.String(), s.Args()...)
+	if err != nil {
+		return nil, wrapSQLError(err, "unable to run select")
+	}
+	defer rows.Close()
+
+	result := make(vocab.ItemCollection, 0, p.size)
+	cursors := make([]cursor, 0, p.size)
+	for rows.Next() {
+		var c cursor
+		var raw []byte
+		if err = rows.Scan(&c.iri, &raw, &c.published); err != nil {
+			return nil, wrapSQLError(err, "scan values error")
+		}
+		it, err := decodeItemFn(raw)
+		if err != nil {
+			return nil, errors.Annotatef(err, "unable to unmarshal raw item")
+		}
+		result = append(result, dereferencePropertiesByType(r, ctx, it, f...))
+		cursors = append(cursors, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, wrapSQLError(err, "unable to load collection items")
+	}
+
+	more := len(result) > p.size
+	if more {
+		result = result[:p.size]
+		cursors = cursors[:p.size]
+	}
+	if backwards {
+		slices.Reverse(result)
+		slices.Reverse(cursors)
+	}
+
+	page := vocab.OrderedCollectionPageNew(&col)
+	page.OrderedItems = result
+	page.First = p.iri(iri, "", nil)
+	if len(cursors) > 0 {
+		if (more && !backwards) || p.before != nil {
+			page.Next = p.iri(iri, afterKeyKey, &cursors[len(cursors)-1])
+		}
+		if (more && backwards) || p.after != nil {
+			page.Prev = p.iri(iri, beforeKeyKey, &cursors[0])
+		}
+	}
+	return page, nil
+}
@@ -0,0 +1,162 @@
+package sqlite
+
+import (
+	"testing"
+
+	"github.com/carlmjohnson/be"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_decodeCursor(t *testing.T) {
+	tests := []struct {
+		name    string
+		arg     string
+		want    *cursor
+		wantErr error
+	}{
+		{
+			name:    "empty",
+			arg:     "",
+			wantErr: errors.BadRequestf("invalid pagination cursor %q", ""),
+		},
+		{
+			name:    "invalid base64",
+			arg:     "!!!",
+			wantErr: errors.BadRequestf("invalid pagination cursor %q", "!!!"),
+		},
+		{
+			name: "valid",
+			arg:  cursor{published: "2001-01-01T00:00:00Z", iri: "https://example.com/1"}.String(),
+			want: &cursor{published: "2001-01-01T00:00:00Z", iri: "https://example.com/1"},
+		},
+		{
+			name: "without published date",
+			arg:  cursor{iri: "https://example.com/1"}.String(),
+			want: &cursor{iri: "https://example.com/1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCursor(tt.arg)
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Errorf("decodeCursor() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+				return
+			}
+			if !cmp.Equal(got, tt.want, cmp.AllowUnexported(cursor{})) {
+				t.Errorf("decodeCursor() = %s", cmp.Diff(tt.want, got, cmp.AllowUnexported(cursor{})))
+			}
+		})
+	}
+}
+
+func loadPage(t *testing.T, r *repo, iri vocab.IRI, ff ...filters.Check) *vocab.OrderedCollectionPage {
+	t.Helper()
+
+	it, err := r.Load(iri, ff...)
+	be.NilErr(t, err)
+
+	page, ok := it.(*vocab.OrderedCollectionPage)
+	if !ok {
+		t.Fatalf("Load(%s) returned %T, expected %T", iri, it, page)
+	}
+	be.Equal(t, iri, page.GetLink())
+	be.Equal(t, rootOutboxIRI, page.PartOf.GetLink())
+	return page
+}
+
+func Test_repo_Load_keysetPages(t *testing.T) {
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap, withGeneratedMocks)
+	t.Cleanup(r.Close)
+
+	const size = 2
+	first := vocab.IRI(string(rootOutboxIRI) + "?pageSize=2")
+
+	pages := make([]*vocab.OrderedCollectionPage, 0)
+	seen := make(vocab.IRIs, 0)
+	for next := first; next != ""; {
+		page := loadPage(t, r, next)
+		if len(page.OrderedItems) > size {
+			t.Fatalf("page %s has %d items, expected at most %d", next, len(page.OrderedItems), size)
+		}
+		be.Equal(t, first, page.First.GetLink())
+		for _, it := range page.OrderedItems {
+			if seen.Contains(it.GetLink()) {
+				t.Errorf("item %s was returned on more than one page", it.GetLink())
+			}
+			seen = append(seen, it.GetLink())
+		}
+		pages = append(pages, page)
+		next = page.Next.GetLink()
+	}
+	be.Equal(t, allActivities.Load().Count(), uint(len(seen)))
+
+	if len(pages) < 2 {
+		t.Fatalf("expected more than one page, received %d", len(pages))
+	}
+	be.Equal(t, vocab.IRI(""), pages[0].Prev.GetLink())
+
+	// NOTE(marius): walking back from the last page needs to return the same pages in reverse
+	for i := len(pages) - 1; i > 0; i-- {
+		prev := loadPage(t, r, pages[i].Prev.GetLink())
+		be.AllEqual(t, pages[i-1].OrderedItems.IRIs(), prev.OrderedItems.IRIs())
+	}
+}
+
+func Test_repo_Load_linksFirstKeysetPage(t *testing.T) {
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap, withGeneratedMocks)
+	t.Cleanup(r.Close)
+
+	it, err := r.Load(rootOutboxIRI)
+	if err != nil {
+		t.Fatalf("unable to load collection: %s", err)
+	}
+	col, ok := it.(*vocab.OrderedCollection)
+	if !ok {
+		t.Fatalf("expected an ordered collection, received %T", it)
+	}
+	if vocab.IsNil(col.First) {
+		t.Fatalf("expected the collection to link to its first page")
+	}
+
+	page := loadPage(t, r, col.First.GetLink())
+	want := col.OrderedItems
+	if len(want) > len(page.OrderedItems) {
+		want = want[:len(page.OrderedItems)]
+	}
+	be.AllEqual(t, want.IRIs(), page.OrderedItems.IRIs())
+}
+
+func Test_repo_Load_invalidCursor(t *testing.T) {
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap)
+	t.Cleanup(r.Close)
+
+	_, err := r.Load(vocab.IRI(string(rootOutboxIRI) + "?afterKey=!!!"))
+	if !errors.IsBadRequest(err) {
+		t.Errorf("Load() with invalid cursor expected bad request error, received %v", err)
+	}
+}
+
+func Test_repo_Load_keysetPagesFiltered(t *testing.T) {
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap, withGeneratedMocks)
+	t.Cleanup(r.Close)
+
+	ff := filters.Checks{filters.HasType(vocab.CreateType)}
+	want := filter(*allActivities.Load(), ff...).IRIs()
+
+	seen := make(vocab.IRIs, 0)
+	for next := vocab.IRI(string(rootOutboxIRI) + "?pageSize=2"); next != ""; {
+		page := loadPage(t, r, next, ff...)
+		for _, it := range page.OrderedItems {
+			be.Equal(t, vocab.CreateType, it.GetType())
+			seen = append(seen, it.GetLink())
+		}
+		next = page.Next.GetLink()
+	}
+	be.Equal(t, len(want), len(seen))
+	for _, iri := range want {
+		be.True(t, seen.Contains(iri))
+	}
+}
@@ -1,13 +1,13 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
-	"runtime"
 	"strings"
 	"time"
 
@@ -15,7 +15,6 @@ import (
 	"github.com/go-ap/cache"
 	"github.com/go-ap/errors"
 	"github.com/go-ap/filters"
-	"github.com/go-ap/jsonld"
 	"github.com/leporo/sqlf"
 )
 
@@ -31,10 +30,46 @@ type Config struct {
 	CacheEnable bool
 	LogFn       loggerFn
 	ErrFn       loggerFn
+	// AutoMigrate makes Open apply the pending schema migrations instead of failing
+	// when the database is behind the current schema version.
+	AutoMigrate bool
+	// MigrateDryRun makes Migrate only log the SQL of the pending migrations, without applying them.
+	MigrateDryRun bool
+	// Checkpoint is the policy for the background checkpoints of the WAL file.
+	Checkpoint CheckpointPolicy
+	// Connection holds the settings for the connections to the database.
+	Connection ConnectionOptions
+	// Driver is the name of the SQLite driver to use, see Drivers for the ones available in the current build.
+	// When empty, the github.com/mattn/go-sqlite3 driver is used for builds with CGO, and modernc.org/sqlite otherwise.
+	Driver string
+	// Retry is the policy for retrying the write operations that fail because the database is locked.
+	Retry RetryPolicy
+	// Cache is the cache used for the loaded and saved items, see NewLRUCache for a bounded one.
+	// When nil, the unbounded cache from github.com/go-ap/cache is used, if CacheEnable is set.
+	Cache cache.CanStore
+	// CacheInvalidationInterval is how often the database is checked for changes made by other processes,
+	// which get removed from the cache. When zero, the cache isn't invalidated.
+	CacheInvalidationInterval time.Duration
+	// ChangesPollInterval is how often the iterators returned by Subscribe check for new changes.
+	// It defaults to one second.
+	ChangesPollInterval time.Duration
+	// Revisions enables keeping the previous versions of the objects and actors when they get replaced,
+	// see WithRevisionActivity, Revisions and LoadRevision.
+	// The revisions are kept until they are pruned, see RetentionPolicy.RevisionsMaxAge and PruneRevisions.
+	Revisions bool
+	// LocalBaseIRIs are the base IRIs of the local instance. The items with IRIs under them are never
+	// removed by CollectGarbage, or by the retention rules.
+	LocalBaseIRIs vocab.IRIs
+	// Retention holds the rules for removing remote content, and for pruning the changes log,
+	// and how often they get applied.
+	Retention RetentionPolicy
 }
 
 // New returns a new repo repository
 func New(c Config) (*repo, error) {
+	if _, err := getDriver(c.Driver); err != nil {
+		return nil, err
+	}
 	p, err := getFullPath(c)
 	if err != nil {
 		return nil, err
@@ -43,9 +78,26 @@ func New(c Config) (*repo, error) {
 		path:  p,
 		logFn: defaultLogFn,
 		errFn: defaultLogFn,
-		cache: cache.New(c.CacheEnable),
+		cache: c.Cache,
+
+		checkVersion: true,
+		autoMigrate:  c.AutoMigrate,
+
+		checkpointPolicy: c.Checkpoint,
+		connOpts:         c.Connection,
+		driver:           c.Driver,
+		retryPolicy:      c.Retry,
+
+		invalidationInterval: c.CacheInvalidationInterval,
+		changesPollInterval:  c.ChangesPollInterval,
+		revisions:            c.Revisions,
+		localBaseIRIs:        c.LocalBaseIRIs,
+		retentionPolicy:      c.Retention,
 	}
 
+	if rr.cache == nil {
+		rr.cache = cache.New(c.CacheEnable)
+	}
 	if c.LogFn != nil {
 		rr.logFn = c.LogFn
 	}
@@ -62,10 +114,50 @@ type repo struct {
 	cache cache.CanStore
 	logFn loggerFn
 	errFn loggerFn
+
+	// checkVersion makes Open verify the schema version of the database, it is set for repositories
+	// created by New.
+	checkVersion bool
+	autoMigrate  bool
+
+	checkpointPolicy CheckpointPolicy
+	checkpointer     *checkpointer
+
+	connOpts    ConnectionOptions
+	driver      string
+	retryPolicy RetryPolicy
+
+	invalidationInterval time.Duration
+	invalidator          *cacheInvalidator
+	changesPollInterval  time.Duration
+	revisions            bool
+	localBaseIRIs        vocab.IRIs
+	retentionPolicy      RetentionPolicy
+	retention            *retentionWorker
+
+	// tx is set only on the copies of the repository passed to Tx callbacks
+	tx *sql.Tx
 }
 
 var errNotOpen = errors.Newf("sqlite db is not open")
 
+// querier is the common set of methods of *sql.DB and *sql.Tx that we use for loading data.
+type querier interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// reader returns the connection used for loading data.
+// When running inside a Tx call, it is the transaction itself, so the uncommitted writes are visible.
+func (r *repo) reader() querier {
+	if r.tx != nil {
+		return r.tx
+	}
+	return r.ro
+}
+
 // Open opens the sqlite database
 func (r *repo) Open() (err error) {
 	if r == nil {
@@ -74,16 +166,39 @@ func (r *repo) Open() (err error) {
 	// NOTE(marius): we split the connection into:
 	if r.conn == nil {
 		// a "write only" connection - allowing only one concurrent query execution
-		if r.conn, err = sqlOpen(r.path); err != nil {
+		if r.conn, err = sqlOpen(r.driver, r.path, r.connOpts); err != nil {
 			return err
 		}
 		r.conn.SetMaxOpenConns(1)
 
 		// and a "read only" connection - which allows multiple connections concurrently
-		if r.ro, err = sqlOpen(r.path); err != nil {
+		if r.ro, err = sqlOpen(r.driver, r.path, r.connOpts); err != nil {
+			r.Close()
 			return err
 		}
-		r.ro.SetMaxOpenConns(max(2, runtime.NumCPU()))
+		r.ro.SetMaxOpenConns(r.connOpts.readPoolSize())
+
+		for _, db := range []*sql.DB{r.conn, r.ro} {
+			if r.connOpts.ConnMaxLifetime > 0 {
+				db.SetConnMaxLifetime(r.connOpts.ConnMaxLifetime)
+			}
+			if r.connOpts.ConnMaxIdleTime > 0 {
+				db.SetConnMaxIdleTime(r.connOpts.ConnMaxIdleTime)
+			}
+		}
+
+		if r.checkVersion {
+			if err = r.checkSchemaVersion(context.Background()); err != nil {
+				r.Close()
+				return err
+			}
+		}
+		r.startCheckpointer()
+		if err = r.startCacheInvalidator(); err != nil {
+			r.Close()
+			return err
+		}
+		r.startRetentionWorker()
 	}
 	return err
 }
@@ -146,6 +261,11 @@ func getCollectionTable(colName vocab.CollectionPath) vocab.CollectionPath {
 
 // Load
 func (r *repo) Load(i vocab.IRI, ff ...filters.Check) (vocab.Item, error) {
+	return r.LoadContext(context.Background(), i, ff...)
+}
+
+// LoadContext
+func (r *repo) LoadContext(ctx context.Context, i vocab.IRI, ff ...filters.Check) (vocab.Item, error) {
 	if r == nil || r.ro == nil {
 		return nil, errNotOpen
 	}
@@ -156,7 +276,7 @@ func (r *repo) Load(i vocab.IRI, ff ...filters.Check) (vocab.Item, error) {
 	if !isCollectionIRI(i) {
 		ff = append(filters.Checks{filters.SameID(i)}, ff...)
 	}
-	it, err := load(r, i, ff...)
+	it, err := load(r, ctx, i, ff...)
 	if err != nil {
 		return nil, err
 	}
@@ -171,6 +291,11 @@ var errNilItem = errors.Errorf("nil item")
 
 // Save
 func (r *repo) Save(it vocab.Item) (vocab.Item, error) {
+	return r.SaveContext(context.Background(), it)
+}
+
+// SaveContext
+func (r *repo) SaveContext(ctx context.Context, it vocab.Item) (vocab.Item, error) {
 	if r == nil || r.conn == nil {
 		return nil, errNotOpen
 	}
@@ -178,100 +303,58 @@ func (r *repo) Save(it vocab.Item) (vocab.Item, error) {
 		return nil, errNilItem
 	}
 
-	tx, err := r.conn.Begin()
+	var saved vocab.Item
+	err := r.writeTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		saved, err = r.save(ctx, tx, it)
+		return err
+	})
 	if err != nil {
-		r.errFn("%s", errors.Annotatef(err, "transaction start error"))
+		return nil, err
 	}
-	defer func() {
-		if err := tx.Commit(); err != nil {
-			r.errFn("%s", errors.Annotatef(err, "transaction commit error"))
-		}
-	}()
-	return r.save(tx, it)
+	if r.cache != nil {
+		r.cache.Store(saved.GetLink(), saved)
+	}
+	return saved, nil
 }
 
-var emptyCol = []byte{'[', ']'}
-
-func (r *repo) removeFrom(tx *sql.Tx, col vocab.IRI, items ...vocab.Item) error {
+func (r *repo) removeFrom(ctx context.Context, tx *sql.Tx, col vocab.IRI, items ...vocab.Item) error {
 	if r.ro == nil || r.conn == nil {
 		return errNotOpen
 	}
 	if col.GetLink() == "" {
 		return errors.NotFoundf("unable to operate on empty collection IRI")
 	}
-	colSel := "SELECT iri, raw, items from collections WHERE iri = ?;"
-	rows, err := tx.Query(colSel, col.GetLink())
+
+	c, err := r.loadCollectionForUpdate(ctx, tx, col.GetLink())
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.NotFoundf("collection not found %s", col.GetLink())
+		}
 		return errors.NotFoundf("unable to load %s", col.GetLink())
 	}
-	defer rows.Close()
-
-	var c vocab.Item
-	var iris vocab.ItemCollection
-	for rows.Next() {
-		var iri string
-		var raw []byte
-		var itemsRaw []byte
 
-		err = rows.Scan(&iri, &raw, &itemsRaw)
-		if err != nil {
-			return errors.Annotatef(err, "scan values error")
-		}
-		c, err = vocab.UnmarshalJSON(raw)
-		if err != nil {
-			return errors.Annotatef(err, "unable to unmarshal Collection")
-		}
-		var itemCol vocab.Item
-		if itemCol, err = vocab.UnmarshalJSON(itemsRaw); err != nil {
-			return errors.Annotatef(err, "unable to unmarshal Collection items")
+	query := "DELETE FROM collection_items WHERE collection_iri = ? AND item_iri = ?;"
+	for _, it := range items {
+		if vocab.IsNil(it) {
+			continue
 		}
-		var ok bool
-		if iris, ok = itemCol.(vocab.ItemCollection); !ok {
-			return errors.Annotatef(err, "unable to load Collection items")
+		if _, err = tx.ExecContext(ctx, query, c.GetLink(), it.GetLink()); err != nil {
+			r.errFn("query error: %s\n%s\n%s", err, stringClean(query), c.GetLink())
+			return wrapSQLError(err, "query error")
 		}
-		iris.Remove(items...)
 	}
 
-	if vocab.IsNil(c) {
-		return errors.NotFoundf("collection not found %s", col.GetLink())
-	}
-
-	typ := c.GetType()
-	if orderedCollectionTypes.Match(typ) {
-		err = vocab.OnOrderedCollection(c, func(col *vocab.OrderedCollection) error {
-			col.TotalItems = iris.Count()
-			col.OrderedItems = nil
-			return nil
-		})
-	} else if collectionTypes.Match(typ) {
-		err = vocab.OnCollection(c, func(col *vocab.Collection) error {
-			col.TotalItems = iris.Count()
-			col.Items = nil
-			return nil
-		})
-	}
-
-	raw, err := vocab.MarshalJSON(c)
-	if err != nil {
-		return errors.Annotatef(err, "unable to marshal collection")
-	}
-	rawItems, err := vocab.MarshalJSON(iris.IRIs())
-	if err != nil {
-		return errors.Annotatef(err, "unable to marshal collection rawItems")
-	}
-
-	query := "UPDATE collections SET raw = ?, items = ? WHERE iri = ?;"
-	_, err = tx.Exec(query, string(raw), string(rawItems), c.GetLink())
-	if err != nil {
-		r.errFn("query error: %s\n%s\n%s", err, stringClean(query), c.GetLink())
-		return errors.Annotatef(err, "query error")
-	}
-
-	return nil
+	return r.updateCollectionTotalItems(ctx, tx, c)
 }
 
 // RemoveFrom
 func (r *repo) RemoveFrom(col vocab.IRI, items ...vocab.Item) error {
+	return r.RemoveFromContext(context.Background(), col, items...)
+}
+
+// RemoveFromContext
+func (r *repo) RemoveFromContext(ctx context.Context, col vocab.IRI, items ...vocab.Item) error {
 	if r == nil || r.conn == nil {
 		return errNotOpen
 	}
@@ -279,112 +362,150 @@ func (r *repo) RemoveFrom(col vocab.IRI, items ...vocab.Item) error {
 		return errors.NotFoundf("unable to operate on empty collection IRI")
 	}
 
-	tx, err := r.conn.Begin()
-	if err != nil {
-		r.errFn("%s", errors.Annotatef(err, "transaction start error"))
-	}
-
-	if err = r.removeFrom(tx, col, items...); err != nil {
-		_ = tx.Rollback()
-		return err
-	}
-	if err := tx.Commit(); err != nil {
-		r.errFn("%s", errors.Annotatef(err, "transaction commit error"))
-		return err
-	}
-
-	return nil
+	return r.writeTx(ctx, func(tx *sql.Tx) error {
+		return r.removeFrom(ctx, tx, col, items...)
+	})
 }
 
-func (r *repo) addTo(tx *sql.Tx, col vocab.IRI, items ...vocab.Item) error {
+func (r *repo) addTo(ctx context.Context, tx *sql.Tx, col vocab.IRI, items ...vocab.Item) error {
 	if r == nil {
 		return errNotOpen
 	}
-	var c vocab.Item
 
-	var iri string
-	var raw []byte
-	var irisRaw []byte
-	iris := make(vocab.IRIs, 0)
-	colSel := "SELECT iri, raw, items from collections WHERE iri = ?;"
-	row := tx.QueryRow(colSel, col)
-	if row != nil {
-		if err := row.Scan(&iri, &raw, &irisRaw); err != nil {
-			r.logFn("unable to load collection object for %s: %s", col, err)
-			if errors.Is(err, sql.ErrNoRows) && (isHiddenCollectionIRI(col)) {
-				// NOTE(marius): this creates blocked/ignored collections if they don't exist
-				if c, err = r.save(tx, createCollection(col.GetLink(), nil)); err != nil {
-					r.errFn("query error: %s\n%s %#v", err, colSel, vocab.IRIs{col})
-				}
-			}
-		} else {
-			c, err = vocab.UnmarshalJSON(raw)
-			if err != nil {
-				return errors.Annotatef(err, "unable to unmarshal Collection")
-			}
-			if err = jsonld.Unmarshal(irisRaw, &iris); err != nil {
-				return errors.Annotatef(err, "unable to unmarshal Collection items")
+	c, err := r.loadCollectionForUpdate(ctx, tx, col.GetLink())
+	if err != nil {
+		r.logFn("unable to load collection object for %s: %s", col, err)
+		if errors.Is(err, sql.ErrNoRows) && (isHiddenCollectionIRI(col)) {
+			// NOTE(marius): this creates blocked/ignored collections if they don't exist
+			if c, err = r.save(ctx, tx, createCollection(col.GetLink(), nil)); err != nil {
+				r.errFn("query error: %s\n%#v", err, vocab.IRIs{col})
 			}
 		}
-		if c == nil {
-			return errors.NotFoundf("collection not found %s", col.GetLink())
-		}
-
-		// NOTE(marius): load previous items' IRIs
-		err := vocab.OnOrderedCollection(c, func(col *vocab.OrderedCollection) error {
-			return iris.Append(col.Collection()...)
-		})
-		if err != nil {
-			return errors.Annotatef(err, "unable to update Collection")
-		}
+	}
+	if vocab.IsNil(c) {
+		return errors.NotFoundf("collection not found %s", col.GetLink())
 	}
 
+	iris := make(vocab.IRIs, 0, len(items))
 	for _, it := range items {
+		if vocab.IsNil(it) {
+			continue
+		}
 		if vocab.IsIRI(it) {
 			// NOTE(marius): append received items to the list
-			if _, err := loadFromThreeTables(r, it.GetLink()); err != nil {
+			if _, err := loadFromThreeTables(r, ctx, it.GetLink()); err != nil {
 				return errors.NewNotFound(err, "invalid item to add to collection")
 			}
 		}
 		_ = iris.Append(it)
 	}
+	if err = r.addCollectionItems(ctx, tx, c.GetLink(), iris...); err != nil {
+		return err
+	}
+
+	return r.updateCollectionTotalItems(ctx, tx, c)
+}
+
+// loadCollectionForUpdate loads the collection object stored at iri.
+// If the object still has its items embedded, they get moved to the collection_items table.
+func (r *repo) loadCollectionForUpdate(ctx context.Context, tx *sql.Tx, iri vocab.IRI) (vocab.Item, error) {
+	var raw []byte
+
+	colSel := "SELECT raw from collections WHERE iri = ?;"
+	if err := tx.QueryRowContext(ctx, colSel, iri).Scan(&raw); err != nil {
+		return nil, err
+	}
+	c, err := vocab.UnmarshalJSON(raw)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to unmarshal Collection")
+	}
+
+	// NOTE(marius): load previous items' IRIs
+	embedded := make(vocab.IRIs, 0)
+	err = vocab.OnCollectionIntf(c, func(col vocab.CollectionInterface) error {
+		return embedded.Append(col.Collection()...)
+	})
+	if err != nil {
+		return nil, wrapSQLError(err, "unable to update Collection")
+	}
+	if err = r.addCollectionItems(ctx, tx, iri, embedded...); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
 
-	rawItems, err := iris.MarshalJSON()
+const insertCollectionItem = `INSERT OR IGNORE INTO collection_items (collection_iri, item_iri, position)
+	SELECT ?, ?, coalesce(max(position), 0) + 1 FROM collection_items WHERE collection_iri = ?;`
+
+// addCollectionItems appends the iris to the end of the collection, skipping the ones that are already part of it.
+func (r *repo) addCollectionItems(ctx context.Context, tx *sql.Tx, col vocab.IRI, iris ...vocab.IRI) error {
+	if len(iris) == 0 {
+		return nil
+	}
+
+	st, err := tx.PrepareContext(ctx, insertCollectionItem)
 	if err != nil {
-		return errors.Annotatef(err, "unable to marshal Collection items")
+		return wrapSQLError(err, "unable to prepare statement")
+	}
+	defer st.Close()
+
+	for _, iri := range iris {
+		if _, err = st.ExecContext(ctx, col, iri, col); err != nil {
+			r.errFn("query error: %s\n%s %#v", err, stringClean(insertCollectionItem), vocab.IRIs{col, iri})
+			return wrapSQLError(err, "query error")
+		}
+	}
+	return nil
+}
+
+// updateCollectionTotalItems sets the totalItems property of the collection object to the number of its items
+// in the collection_items table.
+func (r *repo) updateCollectionTotalItems(ctx context.Context, tx *sql.Tx, c vocab.Item) error {
+	var count uint
+
+	countSel := "SELECT count(*) FROM collection_items WHERE collection_iri = ?;"
+	if err := tx.QueryRowContext(ctx, countSel, c.GetLink()).Scan(&count); err != nil {
+		return wrapSQLError(err, "unable to count Collection items")
 	}
 
+	var err error
 	typ := c.GetType()
 	if orderedCollectionTypes.Match(typ) {
 		err = vocab.OnOrderedCollection(c, func(col *vocab.OrderedCollection) error {
-			col.TotalItems = iris.Count()
+			col.TotalItems = count
 			col.OrderedItems = nil
 			return nil
 		})
 	} else if collectionTypes.Match(typ) {
 		err = vocab.OnCollection(c, func(col *vocab.Collection) error {
-			col.TotalItems = iris.Count()
+			col.TotalItems = count
 			col.Items = nil
 			return nil
 		})
 	}
+	if err != nil {
+		return wrapSQLError(err, "unable to update Collection")
+	}
 
-	raw, err = vocab.MarshalJSON(c)
+	raw, err := vocab.MarshalJSON(c)
 	if err != nil {
 		return errors.Annotatef(err, "unable to marshal Collection")
 	}
-	query := `INSERT OR REPLACE INTO collections (iri, raw, items) VALUES (?, ?, ?);`
-	_, err = tx.Exec(query, col.GetLink(), string(raw), string(rawItems))
-	if err != nil {
+	query := "UPDATE collections SET raw = ? WHERE iri = ?;"
+	if _, err = tx.ExecContext(ctx, query, string(raw), c.GetLink()); err != nil {
 		r.errFn("query error: %s\n%s %#v", err, query, vocab.IRIs{c.GetLink()})
-		return errors.Annotatef(err, "query error")
+		return wrapSQLError(err, "query error")
 	}
-
 	return nil
 }
 
 // AddTo
 func (r *repo) AddTo(col vocab.IRI, items ...vocab.Item) error {
+	return r.AddToContext(context.Background(), col, items...)
+}
+
+// AddToContext
+func (r *repo) AddToContext(ctx context.Context, col vocab.IRI, items ...vocab.Item) error {
 	if r == nil || r.conn == nil {
 		return errNotOpen
 	}
@@ -392,25 +513,18 @@ func (r *repo) AddTo(col vocab.IRI, items ...vocab.Item) error {
 		return errors.NotFoundf("unable to operate on empty collection IRI")
 	}
 
-	tx, err := r.conn.Begin()
-	if err != nil {
-		r.errFn("%s", errors.Annotatef(err, "transaction start error"))
-	}
-
-	if err = r.addTo(tx, col, items...); err != nil {
-		_ = tx.Rollback()
-		return err
-	}
-	if err = tx.Commit(); err != nil {
-		r.errFn("%s", errors.Annotatef(err, "transaction commit error"))
-		return err
-	}
-
-	return nil
+	return r.writeTx(ctx, func(tx *sql.Tx) error {
+		return r.addTo(ctx, tx, col, items...)
+	})
 }
 
 // Delete
 func (r *repo) Delete(it vocab.Item) error {
+	return r.DeleteContext(context.Background(), it)
+}
+
+// DeleteContext
+func (r *repo) DeleteContext(ctx context.Context, it vocab.Item) error {
 	if r == nil || r.conn == nil {
 		return errNotOpen
 	}
@@ -423,7 +537,7 @@ func (r *repo) Delete(it vocab.Item) error {
 		err := vocab.OnCollectionIntf(it, func(c vocab.CollectionInterface) error {
 			var err error
 			for _, it := range c.Collection() {
-				if err = r.Delete(it); err != nil {
+				if err = r.DeleteContext(ctx, it); err != nil {
 					return err
 				}
 			}
@@ -432,7 +546,17 @@ func (r *repo) Delete(it vocab.Item) error {
 		return err
 	}
 
-	return delete(*r, it)
+	var changed vocab.IRIs
+	err := r.writeTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		changed, err = r.delete(ctx, tx, it)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	r.evict(changed...)
+	return nil
 }
 
 const dbFile = "storage.sqlite"
@@ -464,16 +588,16 @@ func mkDirIfNotExists(p string) error {
 	return nil
 }
 
-func saveMetadataToTable(conn *sql.DB, iri vocab.IRI, m []byte) error {
+func saveMetadataToTable(conn *sql.DB, ctx context.Context, iri vocab.IRI, m []byte) error {
 	query := "INSERT OR REPLACE INTO meta (iri, raw) VALUES(?, ?);"
-	_, err := conn.Exec(query, iri, string(m))
+	_, err := conn.ExecContext(ctx, query, iri, string(m))
 	return err
 }
 
-func loadMetadataFromTable(conn *sql.DB, iri vocab.IRI) ([]byte, error) {
+func loadMetadataFromTable(conn *sql.DB, ctx context.Context, iri vocab.IRI) ([]byte, error) {
 	var meta []byte
 	sel := "SELECT raw FROM meta WHERE iri = ?;"
-	err := conn.QueryRow(sel, iri).Scan(&meta)
+	err := conn.QueryRowContext(ctx, sel, iri).Scan(&meta)
 	return meta, err
 }
 
@@ -490,19 +614,9 @@ func stripFiltersFromIRI(iri vocab.IRI) vocab.IRI {
 	return vocab.IRI(u.String())
 }
 
-func loadFromThreeTables(r *repo, iri vocab.IRI, f ...filters.Check) (vocab.CollectionInterface, error) {
-	if isSingleItem(f...) {
-		if len(f) == 0 {
-			f = filters.Checks{filters.SameID(iri)}
-		}
-	}
-	if r.cache != nil {
-		if cachedIt := r.cache.Load(iri); cachedIt != nil {
-			return &vocab.ItemCollection{cachedIt}, nil
-		}
-	}
-
-	conn := r.ro
+// threeTablesQuery returns the statement that selects the iri and raw value of the items matching the filters
+// from the actors, objects and activities tables.
+func threeTablesQuery(f ...filters.Check) *sqlf.Stmt {
 	var unions *sqlf.Stmt
 	for _, table := range []string{"actors", "objects", "activities"} {
 		st := sqlf.From(table)
@@ -515,26 +629,45 @@ func loadFromThreeTables(r *repo, iri vocab.IRI, f ...filters.Check) (vocab.Coll
 		}
 	}
 
-	ret := make(vocab.ItemCollection, 0)
 	topSt := sqlf.From("("+unions.String()+") as x", unions.Args()...)
 	topSt.Select("iri").Select("raw")
 	filters.SQLLimit(topSt, f...)
+	return topSt
+}
+
+func loadFromThreeTables(r *repo, ctx context.Context, iri vocab.IRI, f ...filters.Check) (vocab.CollectionInterface, error) {
+	if isSingleItem(f...) {
+		if len(f) == 0 {
+			f = filters.Checks{filters.SameID(iri)}
+		}
+	}
+	// NOTE(marius): the cache is not used inside a transaction, as it must not get its uncommitted changes
+	if r.cache != nil && r.tx == nil {
+		if cachedIt := r.cache.Load(iri); cachedIt != nil {
+			return &vocab.ItemCollection{cachedIt}, nil
+		}
+	}
+
+	conn := r.reader()
+
+	ret := make(vocab.ItemCollection, 0)
+	topSt := threeTablesQuery(f...)
 
 	sq := topSt.String()
 	ag := topSt.Args()
 
-	st, err := conn.Prepare(sq)
+	st, err := conn.PrepareContext(ctx, sq)
 	if err != nil {
-		return nil, errors.Annotatef(err, "unable to prepare statement")
+		return nil, wrapSQLError(err, "unable to prepare statement")
 	}
 	defer st.Close()
 
-	rows, err := st.Query(ag...)
+	rows, err := st.QueryContext(ctx, ag...)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, errors.NotFoundf("no rows found")
 		}
-		return nil, errors.Annotatef(err, "unable to run select")
+		return nil, wrapSQLError(err, "unable to run select")
 	}
 	defer rows.Close()
 
@@ -543,14 +676,14 @@ func loadFromThreeTables(r *repo, iri vocab.IRI, f ...filters.Check) (vocab.Coll
 		var iri string
 		var raw []byte
 		if err = rows.Scan(&iri, &raw); err != nil {
-			return &ret, errors.Annotatef(err, "scan values error")
+			return &ret, wrapSQLError(err, "scan values error")
 		}
 
 		it, err := decodeItemFn(raw)
 		if err != nil {
 			return &ret, errors.Annotatef(err, "unable to unmarshal raw item")
 		}
-		if vocab.IsObject(it) && r.cache != nil {
+		if vocab.IsObject(it) && r.cache != nil && r.tx == nil {
 			r.cache.Store(it.GetLink(), it)
 		}
 		ret = append(ret, it)
@@ -560,7 +693,7 @@ func loadFromThreeTables(r *repo, iri vocab.IRI, f ...filters.Check) (vocab.Coll
 	}
 
 	for i, it := range ret {
-		ret[i] = firstOrItems(dereferencePropertiesByType(r, it, f...))
+		ret[i] = firstOrItems(dereferencePropertiesByType(r, ctx, it, f...))
 	}
 	return &ret, err
 }
@@ -614,7 +747,7 @@ func isCollectionIRI(iri vocab.IRI) bool {
 	return collectionPaths.Contains(lst)
 }
 
-func dereferencePropertiesByType(r *repo, it vocab.Item, fil ...filters.Check) vocab.Item {
+func dereferencePropertiesByType(r *repo, ctx context.Context, it vocab.Item, fil ...filters.Check) vocab.Item {
 	if vocab.IsNil(it) || vocab.IsIRI(it) {
 		return it
 	}
@@ -631,30 +764,30 @@ func dereferencePropertiesByType(r *repo, it vocab.Item, fil ...filters.Check) v
 	// properties that need to be loaded for sub-filters.
 	if vocab.IntransitiveActivityTypes.Match(typ) /*&& len(intransitiveChecks) > 0*/ {
 		checks := append(intransitiveChecks, authorizedChecks...)
-		_ = vocab.OnIntransitiveActivity(it, loadFilteredPropsForIntransitiveActivity(r, checks...))
+		_ = vocab.OnIntransitiveActivity(it, loadFilteredPropsForIntransitiveActivity(r, ctx, checks...))
 	}
 	if vocab.ActivityTypes.Match(typ) /*&& len(activityChecks) > 0*/ {
 		checks := append(activityChecks, authorizedChecks...)
-		_ = vocab.OnActivity(it, loadFilteredPropsForActivity(r, checks...))
+		_ = vocab.OnActivity(it, loadFilteredPropsForActivity(r, ctx, checks...))
 	}
 	if vocab.ActorTypes.Match(typ) /*&& len(actorChecks) > 0*/ {
 		checks := append(actorChecks, authorizedChecks...)
-		_ = vocab.OnActor(it, loadFilteredPropsForActor(r, checks...))
+		_ = vocab.OnActor(it, loadFilteredPropsForActor(r, ctx, checks...))
 	}
 	if vocab.ObjectTypes.Match(typ) /*&& len(objectChecks) > 0*/ {
 		checks := append(objectChecks, authorizedChecks...)
-		_ = vocab.OnObject(it, loadFilteredPropsForObject(r, checks...))
+		_ = vocab.OnObject(it, loadFilteredPropsForObject(r, ctx, checks...))
 	}
 	return firstOrItems(it)
 }
 
-func loadFilteredPropsForActor(r *repo, fil ...filters.Check) func(a *vocab.Actor) error {
+func loadFilteredPropsForActor(r *repo, ctx context.Context, fil ...filters.Check) func(a *vocab.Actor) error {
 	return func(a *vocab.Actor) error {
-		return vocab.OnObject(a, loadFilteredPropsForObject(r, fil...))
+		return vocab.OnObject(a, loadFilteredPropsForObject(r, ctx, fil...))
 	}
 }
 
-func loadFilteredPropsForActivity(r *repo, fil ...filters.Check) func(a *vocab.Activity) error {
+func loadFilteredPropsForActivity(r *repo, ctx context.Context, fil ...filters.Check) func(a *vocab.Activity) error {
 	objectChecks := filters.ObjectChecks(fil...)
 	return func(a *vocab.Activity) error {
 		var err error
@@ -662,16 +795,16 @@ func loadFilteredPropsForActivity(r *repo, fil ...filters.Check) func(a *vocab.A
 			if a.ID.Equals(a.Object.GetLink(), false) {
 				return errors.BadGatewayf("invalid activity with id %s, referencing itself as an object: %s", a.ID, a.Object.GetLink())
 			}
-			if a.Object, err = dereferenceItemAndFilter(r, a.Object, objectChecks...); err != nil {
+			if a.Object, err = dereferenceItemAndFilter(r, ctx, a.Object, objectChecks...); err != nil {
 				return err
 			}
 		}
 		intransitiveChecks := filters.IntransitiveActivityChecks(fil...)
-		return vocab.OnIntransitiveActivity(a, loadFilteredPropsForIntransitiveActivity(r, intransitiveChecks...))
+		return vocab.OnIntransitiveActivity(a, loadFilteredPropsForIntransitiveActivity(r, ctx, intransitiveChecks...))
 	}
 }
 
-func loadFilteredPropsForIntransitiveActivity(r *repo, fil ...filters.Check) func(a *vocab.IntransitiveActivity) error {
+func loadFilteredPropsForIntransitiveActivity(r *repo, ctx context.Context, fil ...filters.Check) func(a *vocab.IntransitiveActivity) error {
 	targetChecks := filters.TargetChecks(fil...)
 	return func(a *vocab.IntransitiveActivity) error {
 		var err error
@@ -679,18 +812,18 @@ func loadFilteredPropsForIntransitiveActivity(r *repo, fil ...filters.Check) fun
 			if a.ID.Equals(a.Target.GetLink(), false) {
 				return errors.BadGatewayf("invalid activity with id %s, referencing itself as a target: %s", a.ID, a.Target.GetLink())
 			}
-			if a.Target, err = dereferenceItemAndFilter(r, a.Target, targetChecks...); err != nil {
+			if a.Target, err = dereferenceItemAndFilter(r, ctx, a.Target, targetChecks...); err != nil {
 				return err
 			}
-			if a.Actor, err = dereferenceItemAndFilter(r, a.Actor, targetChecks...); err != nil {
+			if a.Actor, err = dereferenceItemAndFilter(r, ctx, a.Actor, targetChecks...); err != nil {
 				return err
 			}
 		}
-		return vocab.OnObject(a, loadFilteredPropsForObject(r))
+		return vocab.OnObject(a, loadFilteredPropsForObject(r, ctx))
 	}
 }
 
-func dereferenceItemAndFilter(r *repo, ob vocab.Item, fil ...filters.Check) (vocab.Item, error) {
+func dereferenceItemAndFilter(r *repo, ctx context.Context, ob vocab.Item, fil ...filters.Check) (vocab.Item, error) {
 	if vocab.IsNil(ob) {
 		return ob, nil
 	}
@@ -699,7 +832,7 @@ func dereferenceItemAndFilter(r *repo, ob vocab.Item, fil ...filters.Check) (voc
 		return ob, nil
 	}
 
-	o, err := loadFromThreeTables(r, ob.GetLink(), fil...)
+	o, err := loadFromThreeTables(r, ctx, ob.GetLink(), fil...)
 	if err != nil {
 		return ob, nil
 	}
@@ -714,7 +847,7 @@ func firstOrItems(it vocab.Item) vocab.Item {
 	return it
 }
 
-func loadFilteredPropsForObject(r *repo, fil ...filters.Check) func(o *vocab.Object) error {
+func loadFilteredPropsForObject(r *repo, ctx context.Context, fil ...filters.Check) func(o *vocab.Object) error {
 	return func(o *vocab.Object) error {
 		if len(o.Tag) == 0 {
 			return nil
@@ -724,7 +857,7 @@ func loadFilteredPropsForObject(r *repo, fil ...filters.Check) func(o *vocab.Obj
 				if vocab.IsNil(t) || !vocab.IsIRI(t) {
 					return nil
 				}
-				items, err := loadFromThreeTables(r, t.GetLink())
+				items, err := loadFromThreeTables(r, ctx, t.GetLink())
 				if err != nil {
 					continue
 				}
@@ -743,12 +876,12 @@ func loadFilteredPropsForObject(r *repo, fil ...filters.Check) func(o *vocab.Obj
 var orderedCollectionTypes = vocab.ActivityVocabularyTypes{vocab.OrderedCollectionPageType, vocab.OrderedCollectionType}
 var collectionTypes = vocab.ActivityVocabularyTypes{vocab.CollectionPageType, vocab.CollectionType}
 
-func load(r *repo, iri vocab.IRI, f ...filters.Check) (vocab.CollectionInterface, error) {
+func load(r *repo, ctx context.Context, iri vocab.IRI, f ...filters.Check) (vocab.CollectionInterface, error) {
 	var items vocab.CollectionInterface
 	var err error
 
 	if !isCollectionIRI(iri) {
-		items, err = loadFromThreeTables(r, iri, f...)
+		items, err = loadFromThreeTables(r, ctx, iri, f...)
 		if err != nil {
 			return items, err
 		}
@@ -758,7 +891,16 @@ func load(r *repo, iri vocab.IRI, f ...filters.Check) (vocab.CollectionInterface
 		}
 		return items, nil
 	}
-	par, err := loadFromCollectionTable(r, colIRI(iri), f...)
+	page, err := keysetPageFromIRI(iri)
+	if err != nil {
+		return nil, err
+	}
+	var par vocab.CollectionInterface
+	if page != nil {
+		par, err = loadCollectionPage(r, ctx, colIRI(iri), *page, f...)
+	} else {
+		par, err = loadFromCollectionTable(r, ctx, colIRI(iri), f...)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -772,12 +914,26 @@ func load(r *repo, iri vocab.IRI, f ...filters.Check) (vocab.CollectionInterface
 	typ := par.GetType()
 	if orderedCollectionTypes.Match(typ) {
 		_ = vocab.OnOrderedCollection(par, postProcessOrderedCollection(par.Collection()))
+		if page == nil && len(f) == 0 {
+			// NOTE(marius): the unpaged collection links to the first of its keyset pages,
+			// the filtered ones get their first page from the filters package.
+			_ = vocab.OnOrderedCollection(par, linkFirstKeysetPage(colIRI(iri)))
+		}
 	} else if collectionTypes.Match(typ) {
 		_ = vocab.OnCollection(par, postProcessCollection(par.Collection()))
 	}
 	return par, err
 }
 
+func linkFirstKeysetPage(iri vocab.IRI) vocab.WithOrderedCollectionFn {
+	return func(col *vocab.OrderedCollection) error {
+		if col.First == nil {
+			col.First = keysetPage{size: filters.MaxItems}.iri(iri, "", nil)
+		}
+		return nil
+	}
+}
+
 func postProcessCollection(items vocab.ItemCollection) vocab.WithCollectionFn {
 	return func(col *vocab.Collection) error {
 		if len(items) > 0 {
@@ -801,8 +957,8 @@ func isHiddenCollectionIRI(i vocab.IRI) bool {
 	return filters.HiddenCollections.Contains(lst)
 }
 
-func loadFromCollectionTable(r *repo, iri vocab.IRI, f ...filters.Check) (vocab.CollectionInterface, error) {
-	conn := r.ro
+func loadFromCollectionTable(r *repo, ctx context.Context, iri vocab.IRI, f ...filters.Check) (vocab.CollectionInterface, error) {
+	conn := r.reader()
 
 	selects := []string{"c.iri = ? "}
 	params := []any{iri}
@@ -823,34 +979,32 @@ func loadFromCollectionTable(r *repo, iri vocab.IRI, f ...filters.Check) (vocab.
 
 	filters.SQLLimit(s, f...)
 	if isStorageCollectionIRI(iri) {
-		s.Select(`json_patch(json(c.raw), json_object('orderedItems', json_group_array(json(x.raw)))) raw`)
+		s.Select(`json_patch(json(c.raw), json_object('orderedItems', json_group_array(json(x.raw) ORDER BY x.published DESC, x.iri DESC))) raw`)
 		s.LeftJoin(string(table)+" x", "true")
-		s.OrderBy("x.published DESC")
 	} else {
-		s.Select(`json_patch(json(c.raw), json_object('orderedItems', json_group_array(json(coalesce(x.raw, y.raw, o.raw))))) raw`)
-		s.From(`json_each(json_insert(c.items, '$[0]', null))`)
-		s.LeftJoin("activities x", "value = x.iri")
-		s.LeftJoin("actors y", "value = y.iri")
-		s.LeftJoin("objects o", "value = o.iri")
-		s.OrderBy("COALESCE(x.published, y.published, o.published) DESC")
+		s.Select(`json_patch(json(c.raw), json_object('orderedItems', json_group_array(json(coalesce(x.raw, y.raw, o.raw)) ORDER BY coalesce(x.published, y.published, o.published) DESC, ci.item_iri DESC))) raw`)
+		s.LeftJoin("collection_items ci", "ci.collection_iri = c.iri")
+		s.LeftJoin("activities x", "ci.item_iri = x.iri")
+		s.LeftJoin("actors y", "ci.item_iri = y.iri")
+		s.LeftJoin("objects o", "ci.item_iri = o.iri")
 	}
 
 	sq := s.String()
 	args := s.Args()
 
-	st, err := conn.Prepare(sq)
+	st, err := conn.PrepareContext(ctx, sq)
 	if err != nil {
-		return nil, errors.Annotatef(err, "unable to prepare statement")
+		return nil, wrapSQLError(err, "unable to prepare statement")
 	}
 	defer st.Close()
 
 	var cIri sql.NullString
 	var raw []byte
-	if err = st.QueryRow(args...).Scan(&cIri, &raw); err != nil {
+	if err = st.QueryRowContext(ctx, args...).Scan(&cIri, &raw); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, errors.NotFoundf("failed to find items in collection %s", iri)
 		}
-		return nil, errors.Annotatef(err, "failed to run select for %s", iri)
+		return nil, wrapSQLError(err, "failed to run select for %s", iri)
 	}
 
 	if len(raw) == 0 {
@@ -864,7 +1018,7 @@ func loadFromCollectionTable(r *repo, iri vocab.IRI, f ...filters.Check) (vocab.
 
 	items := res.Collection()
 	for i, it := range items {
-		items[i] = dereferencePropertiesByType(r, it, f...)
+		items[i] = dereferencePropertiesByType(r, ctx, it, f...)
 	}
 
 	if isStorageCollectionIRI(iri) {
@@ -878,50 +1032,47 @@ func loadFromCollectionTable(r *repo, iri vocab.IRI, f ...filters.Check) (vocab.
 	return &res, err
 }
 
-func delete(r repo, it vocab.Item) error {
+// delete removes it from the database, and from all the collections that contain it.
+// It returns the IRIs of the item and of the changed collections, which need to be removed from the cache
+// after the transaction is committed.
+func (r *repo) delete(ctx context.Context, tx *sql.Tx, it vocab.Item) (vocab.IRIs, error) {
 	iri := it.GetLink()
 	cleanupTables := []string{"meta", "actors", "objects", "activities"}
 
-	if r.cache != nil {
-		r.cache.Delete(iri)
-	}
-
-	tx, err := r.conn.Begin()
-	if err != nil {
-		return err
-	}
-
 	removeFn := func(table string, iri vocab.IRI) error {
 		query := "DELETE FROM " + table + " where iri = $1;"
-		stmt, err := tx.Prepare(query)
+		stmt, err := tx.PrepareContext(ctx, query)
 		if err != nil {
 			r.errFn("query prepare error: %v\t%s", err, query)
-			return errors.Annotatef(err, "query error")
+			return wrapSQLError(err, "query error")
 		}
-		if _, err = stmt.Exec(iri); err != nil {
+		defer stmt.Close()
+
+		if _, err = stmt.ExecContext(ctx, iri); err != nil {
 			r.errFn("query execution error: %v\t%s", err, query)
-			return errors.Annotatef(err, "query error")
+			return wrapSQLError(err, "query error")
 		}
 		return nil
 	}
 
 	for _, tbl := range cleanupTables {
-		if err = removeFn(tbl, iri); err != nil {
-			_ = tx.Rollback()
-			return err
+		if err := removeFn(tbl, iri); err != nil {
+			return nil, err
 		}
 	}
-	if err = tx.Commit(); err != nil {
-		r.errFn("%s", errors.Annotatef(err, "transaction commit error"))
-		return err
+	if err := removeFromSearchIndex(ctx, tx, iri); err != nil {
+		return nil, err
 	}
-
-	return nil
+	_, cols, err := r.removeFromAllCollections(ctx, tx, iri)
+	if err != nil {
+		return nil, err
+	}
+	return append(vocab.IRIs{iri}, cols...), nil
 }
 
 const upsertQ = "INSERT OR REPLACE INTO %s (%s) VALUES (%s);"
 
-func (r *repo) save(tx *sql.Tx, it vocab.Item) (vocab.Item, error) {
+func (r *repo) save(ctx context.Context, tx *sql.Tx, it vocab.Item) (vocab.Item, error) {
 	if vocab.IsNil(it) {
 		return nil, nil
 	}
@@ -941,6 +1092,26 @@ func (r *repo) save(tx *sql.Tx, it vocab.Item) (vocab.Item, error) {
 	tokens := []string{"?, ?"}
 	params := []any{string(raw), iri}
 
+	table := tableForItem(it)
+	if err = r.saveRevision(ctx, tx, table, iri, raw); err != nil {
+		return it, err
+	}
+	query := fmt.Sprintf(`INSERT OR REPLACE INTO %s (%s) VALUES (%s);`, table, strings.Join(columns, ", "), strings.Join(tokens, ", "))
+
+	if _, err = tx.ExecContext(ctx, query, params...); err != nil {
+		return it, wrapSQLError(err, "query error")
+	}
+	if err = updateSearchIndex(ctx, tx, table, iri); err != nil {
+		return it, err
+	}
+	r.addToParentCollection(ctx, tx, it)
+
+	return it, nil
+}
+
+// tableForItem returns the name of the table where it gets stored.
+func tableForItem(it vocab.Item) string {
+	iri := it.GetLink()
 	table := string(filters.ObjectsType)
 	typ := it.GetType()
 	if append(collectionTypes, orderedCollectionTypes...).Match(typ) {
@@ -957,26 +1128,20 @@ func (r *repo) save(tx *sql.Tx, it vocab.Item) (vocab.Item, error) {
 			table = string(filters.ActivitiesType)
 		}
 	}
+	return table
+}
 
-	query := fmt.Sprintf(`INSERT OR REPLACE INTO %s (%s) VALUES (%s);`, table, strings.Join(columns, ", "), strings.Join(tokens, ", "))
-
-	if _, err = tx.Exec(query, params...); err != nil {
-		return it, errors.Annotatef(err, "query error")
-	}
-	col, _ := path.Split(iri.String())
+// addToParentCollection adds it to the collection its IRI is part of, if there is one.
+func (r *repo) addToParentCollection(ctx context.Context, tx *sql.Tx, it vocab.Item) {
+	col, _ := path.Split(it.GetLink().String())
 	if isCollectionIRI(vocab.IRI(col)) {
 		// Add private items to the collections table
 		if colIRI, k := vocab.Split(vocab.IRI(col)); k == "" {
-			if err = r.addTo(tx, colIRI, it); err != nil {
+			if err := r.addTo(ctx, tx, colIRI, it); err != nil {
 				r.logFn("warning adding item: %s: %s", colIRI, err)
 			}
 		}
 	}
-
-	if r.cache != nil {
-		r.cache.Store(it.GetLink(), it)
-	}
-	return it, nil
 }
 
 func createCollection(colIRI vocab.IRI, owner vocab.Item) vocab.CollectionInterface {
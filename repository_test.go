@@ -1,6 +1,7 @@
 package sqlite
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"reflect"
@@ -45,18 +46,6 @@ func saveMocks(t *testing.T, base string, root vocab.Item, mocks ...string) stri
 		values = append(values, mock, it.GetLink())
 		fields = append(fields, "raw", "iri")
 		params = append(params, "?", "?")
-		if table == "collections" {
-			_ = vocab.OnCollectionIntf(it, func(col vocab.CollectionInterface) error {
-				rawItems, err := vocab.MarshalJSON(col.Collection())
-				if err != nil {
-					rawItems = emptyCol
-				}
-				fields = append(fields, "items")
-				values = append(values, string(rawItems))
-				params = append(params, "?")
-				return nil
-			})
-		}
 		query := fmt.Sprintf(upsertQ, table, strings.Join(fields, ", "), strings.Join(params, ", "))
 		res, err := db.Exec(query, values...)
 		be.NilErr(t, err)
@@ -64,6 +53,16 @@ func saveMocks(t *testing.T, base string, root vocab.Item, mocks ...string) stri
 		rows, err := res.RowsAffected()
 		be.NilErr(t, err)
 		be.Equal(t, 1, rows)
+
+		if table == "collections" {
+			_ = vocab.OnCollectionIntf(it, func(col vocab.CollectionInterface) error {
+				for i, item := range col.Collection() {
+					_, err := db.Exec("INSERT INTO collection_items (collection_iri, item_iri, position) VALUES (?, ?, ?);", it.GetLink(), item.GetLink(), i+1)
+					be.NilErr(t, err)
+				}
+				return nil
+			})
+		}
 	}
 	return p
 }
@@ -458,7 +457,7 @@ func Test_repo_AddTo(t *testing.T) {
 			conn := r.conn
 			defer conn.Close()
 
-			sel := "SELECT published, iri, raw, items from collections where iri=?;"
+			sel := "SELECT published, iri, raw, (SELECT count(*) FROM collection_items WHERE collection_iri = c.iri) from collections c where iri=?;"
 			res, err := conn.Query(sel, tt.args.col)
 			be.NilErr(t, err)
 
@@ -466,9 +465,9 @@ func Test_repo_AddTo(t *testing.T) {
 				var pub string
 				var iri string
 				var raw []byte
-				var itemsRaw []byte
+				var count int
 
-				err := res.Scan(&pub, &iri, &raw, &itemsRaw)
+				err := res.Scan(&pub, &iri, &raw, &count)
 				be.NilErr(t, err)
 
 				be.Equal(t, tt.args.col, vocab.IRI(iri))
@@ -482,16 +481,11 @@ func Test_repo_AddTo(t *testing.T) {
 					return nil
 				})
 
-				it, err = vocab.UnmarshalJSON(itemsRaw)
-				be.NilErr(t, err)
-				items, err := vocab.ToItemCollection(it)
-				be.NilErr(t, err)
-
 				expectedCount := 0
 				if tt.args.it != nil {
 					expectedCount = 1
 				}
-				be.Equal(t, expectedCount, len(*items))
+				be.Equal(t, expectedCount, count)
 			}
 		})
 	}
@@ -1105,6 +1099,74 @@ func Test_repo_Load_UnhappyPath(t *testing.T) {
 	}
 }
 
+func Test_repo_LoadContext(t *testing.T) {
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap, withMockItems)
+	t.Cleanup(r.Close)
+
+	canceled, cancelFn := context.WithCancel(context.Background())
+	cancelFn()
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		iri     vocab.IRI
+		want    vocab.Item
+		wantErr error
+	}{
+		{
+			name: "background context",
+			ctx:  context.Background(),
+			iri:  "https://example.com/1",
+			want: &vocab.Object{ID: "https://example.com/1", Type: vocab.NoteType},
+		},
+		{
+			name:    "canceled context",
+			ctx:     canceled,
+			iri:     "https://example.com/1",
+			wantErr: context.Canceled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.LoadContext(tt.ctx, tt.iri)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("LoadContext() error = %v, wanted %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("LoadContext() unexpected error = %v", err)
+				return
+			}
+			if !vocab.ItemsEqual(got, tt.want) {
+				t.Errorf("LoadContext() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_repo_Load_collectionOrder(t *testing.T) {
+	outbox := &vocab.OrderedCollection{ID: "https://example.com/actors/1/outbox", Type: vocab.OrderedCollectionType}
+	first := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType, Published: publishedTime}
+	last := &vocab.Object{ID: "https://example.com/objects/2", Type: vocab.NoteType, Published: publishedTime.Add(2 * time.Hour)}
+	middle := &vocab.Object{ID: "https://example.com/objects/3", Type: vocab.NoteType, Published: publishedTime.Add(time.Hour)}
+
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap,
+		withGeneratedItems(vocab.ItemCollection{outbox, first, last, middle}))
+	t.Cleanup(r.Close)
+	be.NilErr(t, r.AddTo(outbox.ID, first, last, middle))
+
+	// NOTE(marius): the items are loaded newest first, regardless of the order in which they were added
+	it, err := r.Load(outbox.ID)
+	be.NilErr(t, err)
+	err = vocab.OnOrderedCollection(it, func(col *vocab.OrderedCollection) error {
+		be.AllEqual(t, vocab.IRIs{last.ID, middle.ID, first.ID}, col.OrderedItems.IRIs())
+		return nil
+	})
+	be.NilErr(t, err)
+}
+
 func Test_repo_Load(t *testing.T) {
 	// NOTE(marius): happy path tests for a fully mocked repo
 	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap, withGeneratedMocks)
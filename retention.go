@@ -0,0 +1,332 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// RetentionRule describes which remote content gets removed.
+// A rule can have an age limit, a collection limit, or both.
+type RetentionRule struct {
+	// Name identifies the rule in the logs and in the results of ApplyRetention.
+	Name string
+	// Types limits the age limit to the objects and activities of these types.
+	// When empty, it applies to all of them.
+	Types vocab.ActivityVocabularyTypes
+	// MaxAge is the age limit: the remote objects and activities last updated, or published, longer than
+	// MaxAge ago get removed.
+	MaxAge time.Duration
+	// KeepLocalInteractions keeps the items that are liked, or replied to, by local actors, regardless of their age.
+	KeepLocalInteractions bool
+	// Collection is the path of the remote collections, eg: vocab.Outbox, that get limited to their last
+	// KeepLast items.
+	Collection vocab.CollectionPath
+	// KeepLast is the number of items kept in the collections matching Collection.
+	KeepLast int
+}
+
+func (rule RetentionRule) validate() error {
+	if rule.MaxAge <= 0 && rule.Collection == "" {
+		return errors.Newf("retention rule %q has neither an age limit nor a collection limit", rule.Name)
+	}
+	if rule.Collection != "" && rule.KeepLast <= 0 {
+		return errors.Newf("retention rule %q needs a positive number of items to keep in %s", rule.Name, rule.Collection)
+	}
+	return nil
+}
+
+// RetentionPolicy configures the background worker that applies the retention rules.
+type RetentionPolicy struct {
+	// Interval is how often the rules are applied. When zero, they are only applied by calling ApplyRetention.
+	Interval time.Duration
+	// Rules are applied in order.
+	Rules []RetentionRule
+	// ChangesMaxAge is how long the entries of the changes log are kept, when zero they are never pruned.
+	//
+	// NOTE(marius): the Subscribe iterators resuming from a sequence number that was pruned don't receive
+	// the removed entries, so it should be longer than the time their workers can be stopped for.
+	// The entries that the cache invalidation has not checked yet are always kept.
+	ChangesMaxAge time.Duration
+	// RevisionsMaxAge is how long the revisions of the objects and actors are kept, when zero they are
+	// kept regardless of their age.
+	RevisionsMaxAge time.Duration
+	// KeepRevisions is the number of the most recent revisions kept for each object or actor, when zero
+	// all of them are kept.
+	KeepRevisions int
+}
+
+func (p RetentionPolicy) empty() bool {
+	return len(p.Rules) == 0 && p.ChangesMaxAge <= 0 && p.RevisionsMaxAge <= 0 && p.KeepRevisions <= 0
+}
+
+const (
+	// RetentionChanges is the name of the result for the entries pruned from the changes log by ApplyRetention.
+	RetentionChanges = "changes"
+	// RetentionRevisions is the name of the result for the revisions pruned by ApplyRetention.
+	RetentionRevisions = "revisions"
+)
+
+// RetentionResult holds what a retention rule removed.
+type RetentionResult struct {
+	Rule string
+	// Removed is the number of objects and activities removed.
+	Removed int64
+	// CollectionItems is the number of items removed from the collections limited by the rule.
+	CollectionItems int64
+}
+
+// ApplyRetention applies the rules of the retention policy, and returns what each of them removed.
+// When the policy has a ChangesMaxAge, it also prunes the changes log, with the result named RetentionChanges,
+// and when it has a RevisionsMaxAge or KeepRevisions, it prunes the revisions, with the result named RetentionRevisions.
+//
+// The rules never remove the items with IRIs under the Config.LocalBaseIRIs, and the items that are part
+// of a local collection. The removed items are also removed from the remote collections they were part of.
+func (r *repo) ApplyRetention(ctx context.Context) ([]RetentionResult, error) {
+	if r == nil || r.conn == nil {
+		return nil, errNotOpen
+	}
+	if r.retentionPolicy.empty() {
+		return nil, nil
+	}
+	if len(r.retentionPolicy.Rules) > 0 && len(r.localBaseIRIs) == 0 {
+		return nil, errors.Newf("unable to apply the retention rules without the local base IRIs")
+	}
+	for _, rule := range r.retentionPolicy.Rules {
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]RetentionResult, 0, len(r.retentionPolicy.Rules)+2)
+	for _, rule := range r.retentionPolicy.Rules {
+		res := RetentionResult{Rule: rule.Name}
+		var err error
+		if rule.Collection != "" {
+			err = r.limitCollections(ctx, rule, &res)
+		}
+		if err == nil && rule.MaxAge > 0 {
+			err = r.removeExpired(ctx, rule, &res)
+		}
+		results = append(results, res)
+		if err != nil {
+			return results, errors.Annotatef(err, "unable to apply retention rule %q", rule.Name)
+		}
+	}
+	if r.retentionPolicy.ChangesMaxAge > 0 {
+		n, err := r.PruneChanges(ctx, time.Now().Add(-r.retentionPolicy.ChangesMaxAge))
+		results = append(results, RetentionResult{Rule: RetentionChanges, Removed: n})
+		if err != nil {
+			return results, errors.Annotatef(err, "unable to prune the changes log")
+		}
+	}
+	if p := r.retentionPolicy; p.RevisionsMaxAge > 0 || p.KeepRevisions > 0 {
+		var before time.Time
+		if p.RevisionsMaxAge > 0 {
+			before = time.Now().Add(-p.RevisionsMaxAge)
+		}
+		n, err := r.PruneRevisions(ctx, before, p.KeepRevisions)
+		results = append(results, RetentionResult{Rule: RetentionRevisions, Removed: n})
+		if err != nil {
+			return results, errors.Annotatef(err, "unable to prune the revisions")
+		}
+	}
+	return results, nil
+}
+
+// retentionTables are the tables the age limits of the retention rules apply to.
+var retentionTables = []string{"objects", "activities"}
+
+// expiredQuery returns the query selecting the items of table that are removed by the age limit of rule, and its arguments.
+func (r *repo) expiredQuery(table string, rule RetentionRule, before time.Time) (string, []any) {
+	localIRI, localArgs := localIRIsCond("iri", r.localBaseIRIs)
+	localCol, localColArgs := localIRIsCond("collection_iri", r.localBaseIRIs)
+
+	// NOTE(marius): the dates are compared using datetime, as the stored values can have different formats and time zones
+	conds := []string{"datetime(updated) < datetime(?)", "NOT " + localIRI}
+	args := append([]any{before.UTC().Format(time.RFC3339)}, localArgs...)
+	if len(rule.Types) > 0 {
+		conds = append(conds, "type IN (?"+strings.Repeat(", ?", len(rule.Types)-1)+")")
+		for _, typ := range rule.Types {
+			args = append(args, string(typ))
+		}
+	}
+	conds = append(conds, "iri NOT IN (SELECT item_iri FROM collection_items WHERE "+localCol+")")
+	args = append(args, localColArgs...)
+
+	if rule.KeepLocalInteractions {
+		localActor, localActorArgs := localIRIsCond("actor", r.localBaseIRIs)
+		localAuthor, localAuthorArgs := localIRIsCond("json_extract(raw, '$.attributedTo')", r.localBaseIRIs)
+		conds = append(conds,
+			"iri NOT IN (SELECT object FROM activities WHERE type = 'Like' AND object IS NOT NULL AND "+localActor+")",
+			"iri NOT IN (SELECT json_extract(raw, '$.inReplyTo') FROM objects WHERE json_type(raw, '$.inReplyTo') = 'text' AND "+localAuthor+")",
+		)
+		args = append(args, localActorArgs...)
+		args = append(args, localAuthorArgs...)
+	}
+	args = append(args, defaultGCBatchSize)
+
+	return "SELECT iri FROM " + table + " WHERE " + strings.Join(conds, " AND ") + " ORDER BY iri LIMIT ?;", args
+}
+
+// removeExpired removes the remote objects and activities older than the age limit of rule.
+func (r *repo) removeExpired(ctx context.Context, rule RetentionRule, res *RetentionResult) error {
+	before := time.Now().Add(-rule.MaxAge)
+	for _, table := range retentionTables {
+		query, args := r.expiredQuery(table, rule, before)
+		n, err := r.deleteInBatches(ctx, query, args, nil)
+		res.Removed += n
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// limitCollections removes from the remote collections matching rule.Collection all but their last rule.KeepLast items.
+// The items removed from the collections are also removed from the database, if they are not local,
+// and are not part of any other collection.
+func (r *repo) limitCollections(ctx context.Context, rule RetentionRule, res *RetentionResult) error {
+	localIRI, localArgs := localIRIsCond("iri", r.localBaseIRIs)
+	sel := "SELECT iri FROM collections WHERE iri LIKE ? ESCAPE '\\' AND NOT " + localIRI + " ORDER BY iri;"
+	args := append([]any{"%/" + likeEscape(string(rule.Collection))}, localArgs...)
+
+	cols, err := selectIRIs(ctx, r.reader(), sel, args...)
+	if err != nil {
+		return err
+	}
+	for _, col := range cols {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		var items, removed int64
+		var changed vocab.IRIs
+		err = r.writeTx(ctx, func(tx *sql.Tx) error {
+			var err error
+			changed = changed[:0]
+			items, removed, err = r.limitCollection(ctx, tx, col, rule.KeepLast, &changed)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		r.evict(changed...)
+		res.CollectionItems += items
+		res.Removed += removed
+	}
+	return nil
+}
+
+const (
+	selectOverLimitItems = "SELECT item_iri FROM collection_items WHERE collection_iri = ? ORDER BY position DESC LIMIT -1 OFFSET ?;"
+	countMemberships     = "SELECT count(*) FROM collection_items WHERE item_iri = ?;"
+)
+
+// limitCollection removes all but the last keep items from col, and returns the number of items removed
+// from the collection, and from the database. The IRIs of the changed items are appended to changed.
+func (r *repo) limitCollection(ctx context.Context, tx *sql.Tx, col vocab.IRI, keep int, changed *vocab.IRIs) (int64, int64, error) {
+	// NOTE(marius): the collection is loaded before selecting the items to remove, so the ones still embedded
+	// in its raw value are moved to the collection_items table first, and can't bring them back.
+	c, err := r.loadCollectionForUpdate(ctx, tx, col)
+	if err != nil {
+		return 0, 0, wrapSQLError(err, "unable to load collection %s", col)
+	}
+	over, err := selectIRIs(ctx, tx, selectOverLimitItems, col, keep)
+	if err != nil || len(over) == 0 {
+		return 0, 0, err
+	}
+	for _, it := range over {
+		query := "DELETE FROM collection_items WHERE collection_iri = ? AND item_iri = ?;"
+		if _, err = tx.ExecContext(ctx, query, col, it); err != nil {
+			return 0, 0, wrapSQLError(err, "unable to remove %s from %s", it, col)
+		}
+	}
+	if err = r.updateCollectionTotalItems(ctx, tx, c); err != nil {
+		return 0, 0, err
+	}
+	*changed = append(*changed, col)
+
+	var removed int64
+	for _, it := range over {
+		if r.isLocalIRI(it) {
+			continue
+		}
+		var count int
+		if err = tx.QueryRowContext(ctx, countMemberships, it).Scan(&count); err != nil {
+			return 0, 0, wrapSQLError(err, "unable to load the collections of %s", it)
+		}
+		if count > 0 {
+			continue
+		}
+		cols, err := r.delete(ctx, tx, it)
+		if err != nil {
+			return 0, 0, err
+		}
+		*changed = append(*changed, cols...)
+		removed++
+	}
+	return int64(len(over)), removed, nil
+}
+
+func (r *repo) isLocalIRI(iri vocab.IRI) bool {
+	for _, base := range r.localBaseIRIs {
+		if strings.HasPrefix(iri.String(), base.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// retentionWorker holds the state of the goroutine applying the retention rules.
+type retentionWorker struct {
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// startRetentionWorker starts the goroutine that applies the retention rules every r.retentionPolicy.Interval.
+func (r *repo) startRetentionWorker() {
+	if r.retentionPolicy.Interval <= 0 || r.retentionPolicy.empty() || r.retention != nil {
+		return
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	w := retentionWorker{stop: stop, done: make(chan struct{})}
+	r.retention = &w
+
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(r.retentionPolicy.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				results, err := r.ApplyRetention(ctx)
+				if err != nil && !errors.Is(err, context.Canceled) {
+					r.errFn("%s", errors.Annotatef(err, "retention error"))
+				}
+				for _, res := range results {
+					if res.Removed > 0 || res.CollectionItems > 0 {
+						r.logFn("retention rule %q removed %d items and %d collection items", res.Rule, res.Removed, res.CollectionItems)
+					}
+				}
+			}
+		}
+	}()
+}
+
+// stopRetentionWorker stops the retention goroutine, and waits for it to finish.
+func (r *repo) stopRetentionWorker() {
+	if r.retention == nil {
+		return
+	}
+	r.retention.stop()
+	<-r.retention.done
+	r.retention = nil
+}
@@ -0,0 +1,284 @@
+package sqlite
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+func withRetention(policy RetentionPolicy) initFn {
+	return func(_ *testing.T, r *repo) *repo {
+		r.retentionPolicy = policy
+		return r
+	}
+}
+
+var (
+	retentionOld = time.Now().UTC().Add(-100 * 24 * time.Hour).Truncate(time.Second)
+
+	localActor = &vocab.Actor{ID: "https://example.com/actors/1", Type: vocab.PersonType}
+	localInbox = &vocab.OrderedCollection{ID: "https://example.com/actors/1/inbox", Type: vocab.OrderedCollectionType}
+
+	oldNote     = &vocab.Object{ID: "https://remote.org/notes/1", Type: vocab.NoteType, Published: retentionOld}
+	likedNote   = &vocab.Object{ID: "https://remote.org/notes/2", Type: vocab.NoteType, Published: retentionOld}
+	repliedNote = &vocab.Object{ID: "https://remote.org/notes/3", Type: vocab.NoteType, Published: retentionOld}
+	inboxNote   = &vocab.Object{ID: "https://remote.org/notes/4", Type: vocab.NoteType, Published: retentionOld}
+	recentNote  = &vocab.Object{ID: "https://remote.org/notes/5", Type: vocab.NoteType, Published: time.Now().UTC()}
+	oldArticle  = &vocab.Object{ID: "https://remote.org/articles/1", Type: vocab.ArticleType, Published: retentionOld}
+
+	localLike  = &vocab.Activity{ID: "https://example.com/likes/1", Type: vocab.LikeType, Actor: localActor.ID, Object: likedNote.ID}
+	localReply = &vocab.Object{ID: "https://example.com/notes/1", Type: vocab.NoteType, AttributedTo: localActor.ID, InReplyTo: repliedNote.ID, Published: retentionOld}
+
+	remoteOutbox  = &vocab.OrderedCollection{ID: "https://remote.org/users/alice/outbox", Type: vocab.OrderedCollectionType}
+	remoteCreate1 = &vocab.Activity{ID: "https://remote.org/users/alice/statuses/1", Type: vocab.CreateType, Actor: vocab.IRI("https://remote.org/users/alice")}
+	remoteCreate2 = &vocab.Activity{ID: "https://remote.org/users/alice/statuses/2", Type: vocab.CreateType, Actor: vocab.IRI("https://remote.org/users/alice")}
+	remoteCreate3 = &vocab.Activity{ID: "https://remote.org/users/alice/statuses/3", Type: vocab.CreateType, Actor: vocab.IRI("https://remote.org/users/alice")}
+
+	retentionItems = vocab.ItemCollection{
+		localActor, localInbox, oldNote, likedNote, repliedNote, inboxNote, recentNote, oldArticle, localLike, localReply,
+		remoteOutbox, remoteCreate1, remoteCreate2, remoteCreate3,
+	}
+
+	notesRule = RetentionRule{
+		Name:                  "notes",
+		Types:                 vocab.ActivityVocabularyTypes{vocab.NoteType},
+		MaxAge:                90 * 24 * time.Hour,
+		KeepLocalInteractions: true,
+	}
+	outboxRule = RetentionRule{Name: "outboxes", Collection: vocab.Outbox, KeepLast: 1}
+)
+
+func withRetentionItems(t *testing.T, r *repo) *repo {
+	withGeneratedItems(retentionItems)(t, r)
+	be.NilErr(t, r.AddTo(localInbox.ID, inboxNote, remoteCreate1))
+	be.NilErr(t, r.AddTo(remoteOutbox.ID, remoteCreate1, remoteCreate2, remoteCreate3))
+	return r
+}
+
+func Test_repo_ApplyRetention(t *testing.T) {
+	local := withLocalBaseIRIs("https://example.com")
+	tests := []struct {
+		name        string
+		setupFns    []initFn
+		want        []RetentionResult
+		wantRemoved vocab.IRIs
+		wantErr     error
+	}{
+		{
+			name:    "not open",
+			wantErr: errNotOpen,
+		},
+		{
+			name:     "no rules",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withRetentionItems, local},
+		},
+		{
+			name:     "no local IRIs",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withRetention(RetentionPolicy{Rules: []RetentionRule{notesRule}})},
+			wantErr:  errors.Newf("unable to apply the retention rules without the local base IRIs"),
+		},
+		{
+			name:     "invalid rule",
+			setupFns: []initFn{withOpenRoot, withBootstrap, local, withRetention(RetentionPolicy{Rules: []RetentionRule{{Name: "empty"}}})},
+			wantErr:  errors.Newf("retention rule %q has neither an age limit nor a collection limit", "empty"),
+		},
+		{
+			name: "invalid collection rule",
+			setupFns: []initFn{withOpenRoot, withBootstrap, local,
+				withRetention(RetentionPolicy{Rules: []RetentionRule{{Name: "outboxes", Collection: vocab.Outbox}}})},
+			wantErr: errors.Newf("retention rule %q needs a positive number of items to keep in %s", "outboxes", vocab.Outbox),
+		},
+		{
+			name: "old notes",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withRetentionItems, local,
+				withRetention(RetentionPolicy{Rules: []RetentionRule{notesRule}})},
+			want:        []RetentionResult{{Rule: "notes", Removed: 1}},
+			wantRemoved: vocab.IRIs{oldNote.ID},
+		},
+		{
+			name: "old notes, without keeping interactions",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withRetentionItems, local,
+				withRetention(RetentionPolicy{Rules: []RetentionRule{{Name: "notes", Types: notesRule.Types, MaxAge: notesRule.MaxAge}}})},
+			want:        []RetentionResult{{Rule: "notes", Removed: 3}},
+			wantRemoved: vocab.IRIs{oldNote.ID, likedNote.ID, repliedNote.ID},
+		},
+		{
+			name: "last items in remote outboxes",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withRetentionItems, local,
+				withRetention(RetentionPolicy{Rules: []RetentionRule{outboxRule}})},
+			want:        []RetentionResult{{Rule: "outboxes", Removed: 1, CollectionItems: 2}},
+			wantRemoved: vocab.IRIs{remoteCreate2.ID},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mockRepo(t, fields{path: t.TempDir()}, tt.setupFns...)
+			t.Cleanup(r.Close)
+
+			got, err := r.ApplyRetention(context.Background())
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Fatalf("ApplyRetention() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if !cmp.Equal(tt.want, got) {
+				t.Errorf("ApplyRetention() results differ %s", cmp.Diff(tt.want, got))
+			}
+			if len(tt.setupFns) == 0 {
+				return
+			}
+
+			for _, it := range retentionItems {
+				_, err = r.Load(it.GetLink())
+				if slices.Contains(tt.wantRemoved, it.GetLink()) {
+					if !errors.IsNotFound(err) {
+						t.Errorf("Load(%s) expected not found error for removed item, received %v", it.GetLink(), err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Errorf("Load(%s) expected item to be kept, received %v", it.GetLink(), err)
+				}
+			}
+		})
+	}
+}
+
+func Test_repo_ApplyRetention_limitsCollection(t *testing.T) {
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap, withRetentionItems,
+		withLocalBaseIRIs("https://example.com"), withRetention(RetentionPolicy{Rules: []RetentionRule{outboxRule}}))
+	t.Cleanup(r.Close)
+
+	_, err := r.ApplyRetention(context.Background())
+	be.NilErr(t, err)
+
+	it, err := r.Load(remoteOutbox.ID)
+	be.NilErr(t, err)
+	err = vocab.OnOrderedCollection(it, func(c *vocab.OrderedCollection) error {
+		be.Equal(t, uint(1), c.TotalItems)
+		be.True(t, c.OrderedItems.Contains(remoteCreate3.ID))
+		return nil
+	})
+	be.NilErr(t, err)
+
+	// NOTE(marius): the activity removed from the remote outbox is kept, as it is part of a local collection
+	cols, err := r.CollectionsContaining(context.Background(), remoteCreate1.ID)
+	be.NilErr(t, err)
+	be.AllEqual(t, vocab.IRIs{localInbox.ID}, cols)
+}
+
+func Test_repo_ApplyRetention_limitsCollectionWithEmbeddedItems(t *testing.T) {
+	// NOTE(marius): the collection still has its items embedded in its raw value
+	outbox := &vocab.OrderedCollection{
+		ID:           "https://remote.org/users/bob/outbox",
+		Type:         vocab.OrderedCollectionType,
+		OrderedItems: vocab.ItemCollection{remoteCreate1.ID, remoteCreate2.ID, remoteCreate3.ID},
+	}
+	withEmbeddedItems := func(t *testing.T, r *repo) *repo {
+		withGeneratedItems(vocab.ItemCollection{outbox, remoteCreate1, remoteCreate2, remoteCreate3})(t, r)
+		query := "INSERT INTO collection_items (collection_iri, item_iri, position) VALUES (?, ?, 1), (?, ?, 2), (?, ?, 3);"
+		_, err := r.conn.Exec(query, outbox.ID, remoteCreate1.ID, outbox.ID, remoteCreate2.ID, outbox.ID, remoteCreate3.ID)
+		be.NilErr(t, err)
+		return r
+	}
+
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap, withEmbeddedItems,
+		withLocalBaseIRIs("https://example.com"), withRetention(RetentionPolicy{Rules: []RetentionRule{outboxRule}}))
+	t.Cleanup(r.Close)
+
+	got, err := r.ApplyRetention(context.Background())
+	be.NilErr(t, err)
+	be.AllEqual(t, []RetentionResult{{Rule: "outboxes", Removed: 2, CollectionItems: 2}}, got)
+
+	it, err := r.Load(outbox.ID)
+	be.NilErr(t, err)
+	err = vocab.OnOrderedCollection(it, func(c *vocab.OrderedCollection) error {
+		be.Equal(t, uint(1), c.TotalItems)
+		be.AllEqual(t, vocab.IRIs{remoteCreate3.ID}, c.OrderedItems.IRIs())
+		return nil
+	})
+	be.NilErr(t, err)
+
+	cols, err := r.CollectionsContaining(context.Background(), remoteCreate1.ID)
+	be.NilErr(t, err)
+	be.Equal(t, 0, len(cols))
+}
+
+func Test_repo_ApplyRetention_pruneChanges(t *testing.T) {
+	withOldChanges := func(t *testing.T, r *repo) *repo {
+		_, err := r.conn.Exec("UPDATE changes SET published = datetime('now', '-2 days');")
+		be.NilErr(t, err)
+		return r
+	}
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap, withRetentionItems, withOldChanges,
+		withRetention(RetentionPolicy{ChangesMaxAge: 24 * time.Hour}))
+	t.Cleanup(r.Close)
+
+	_, err := r.Save(&vocab.Object{ID: "https://example.com/notes/2", Type: vocab.NoteType})
+	be.NilErr(t, err)
+
+	var old, total int64
+	be.NilErr(t, r.conn.QueryRow("SELECT count(*) FROM changes WHERE published < datetime('now', '-1 day');").Scan(&old))
+	be.True(t, old > 0)
+
+	got, err := r.ApplyRetention(context.Background())
+	be.NilErr(t, err)
+	be.AllEqual(t, []RetentionResult{{Rule: RetentionChanges, Removed: old}}, got)
+
+	be.NilErr(t, r.conn.QueryRow("SELECT count(*) FROM changes;").Scan(&total))
+	be.True(t, total > 0)
+}
+
+func Test_repo_ApplyRetention_pruneRevisions(t *testing.T) {
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap, withRevisions,
+		withRetention(RetentionPolicy{KeepRevisions: 1}))
+	t.Cleanup(r.Close)
+
+	for _, content := range []string{"v1", "v2", "v3"} {
+		_, err := r.Save(&vocab.Object{ID: "https://example.com/notes/1", Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage(content)})
+		be.NilErr(t, err)
+	}
+
+	got, err := r.ApplyRetention(context.Background())
+	be.NilErr(t, err)
+	be.AllEqual(t, []RetentionResult{{Rule: RetentionRevisions, Removed: 1}}, got)
+
+	revisions, err := r.Revisions(context.Background(), "https://example.com/notes/1")
+	be.NilErr(t, err)
+	be.Equal(t, 1, len(revisions))
+}
+
+func Test_repo_startRetentionWorker(t *testing.T) {
+	policy := RetentionPolicy{Interval: 10 * time.Millisecond, Rules: []RetentionRule{notesRule}}
+	r := mockRepo(t, fields{path: t.TempDir()}, withRetention(policy), withLocalBaseIRIs("https://example.com"),
+		withOpenRoot, withBootstrap, withRetentionItems)
+	t.Cleanup(r.Close)
+
+	if r.retention == nil {
+		t.Fatalf("startRetentionWorker() expected the retention worker to be running")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, err := r.Load(oldNote.ID)
+		if errors.IsNotFound(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("retention worker did not remove %s, Load() error %v", oldNote.ID, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	r.Close()
+	if r.retention != nil {
+		t.Errorf("stopRetentionWorker() expected the retention worker to be stopped")
+	}
+}
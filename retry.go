@@ -0,0 +1,135 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"math/rand/v2"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+const (
+	defaultRetryMaxAttempts    = 5
+	defaultRetryInitialBackoff = 10 * time.Millisecond
+	defaultRetryMaxBackoff     = 500 * time.Millisecond
+)
+
+// RetryPolicy configures how the write operations are retried when they fail because the database
+// is locked by another connection.
+// The zero value uses 5 attempts, with the backoff starting at 10ms and growing up to 500ms.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a write is attempted, set it to 1 to disable the retries.
+	MaxAttempts int
+	// InitialBackoff is the maximum time to wait before the first retry. It is doubled after every attempt.
+	InitialBackoff time.Duration
+	// MaxBackoff is the upper limit of the time to wait between two attempts.
+	MaxBackoff time.Duration
+}
+
+func (p RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts > 0 {
+		return p.MaxAttempts
+	}
+	return defaultRetryMaxAttempts
+}
+
+// backoff returns a random duration, up to the exponential backoff for the attempt.
+// NOTE(marius): the jitter prevents the writers that failed together to retry at the same time.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = defaultRetryInitialBackoff
+	}
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+	d := initial
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, maxBackoff)
+	return d/2 + rand.N(d/2+1)
+}
+
+// retry calls fn until it succeeds, it returns an error that can't be retried, or it runs out of attempts.
+func (r *repo) retry(ctx context.Context, fn func() error) error {
+	attempts := r.retryPolicy.maxAttempts()
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || !IsRetryable(err) || attempt >= attempts {
+			return err
+		}
+		wait := r.retryPolicy.backoff(attempt)
+		r.logFn("database is busy, retrying in %s (attempt %d of %d)", wait, attempt+1, attempts)
+		select {
+		case <-ctx.Done():
+			return errors.Annotatef(ctx.Err(), "retry interrupted, last error: %s", err)
+		case <-time.After(wait):
+		}
+	}
+}
+
+// exec runs query on the write connection, retrying it while the database is busy.
+func (r *repo) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	var res sql.Result
+	err := r.retry(ctx, func() error {
+		var err error
+		res, err = r.conn.ExecContext(ctx, query, args...)
+		return err
+	})
+	return res, err
+}
+
+// writeTx runs fn inside a write transaction, which is committed if fn returns a nil error, and is
+// rolled back otherwise. The whole transaction is retried while the database is busy, so fn must not
+// have side effects outside of tx.
+func (r *repo) writeTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return r.retry(ctx, func() error {
+		tx, err := r.conn.BeginTx(ctx, nil)
+		if err != nil {
+			return wrapSQLError(err, "transaction start error")
+		}
+		var from, to int64
+		inv := r.invalidator
+		if inv != nil {
+			// NOTE(marius): the transaction holds the write lock from the start, so all the changes
+			// logged while it runs are its own, and the cache invalidation can skip them.
+			from, err = loadLastChangeSeq(ctx, tx)
+		}
+		if err == nil {
+			err = fn(tx)
+		}
+		if err == nil && inv != nil {
+			to, err = loadLastChangeSeq(ctx, tx)
+		}
+		if err != nil {
+			if rErr := tx.Rollback(); rErr != nil {
+				r.errFn("%s", errors.Annotatef(rErr, "transaction rollback error"))
+			}
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return wrapSQLError(err, "transaction commit error")
+		}
+		if inv != nil {
+			inv.addOwn(from, to)
+		}
+		return nil
+	})
+}
+
+// evict removes the items at iris from the cache.
+// As the functions run by writeTx must not have side effects, the items they change are evicted
+// only after the transaction is done.
+func (r *repo) evict(iris ...vocab.IRI) {
+	if r.cache == nil {
+		return
+	}
+	for _, iri := range iris {
+		r.cache.Delete(iri)
+	}
+}
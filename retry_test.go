@@ -0,0 +1,245 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// lockDatabase starts a write transaction on a separate connection to the database of r,
+// and returns the function that releases it.
+func lockDatabase(t *testing.T, r *repo) func() {
+	db, err := sqlOpen(r.driver, r.path, ConnectionOptions{})
+	be.NilErr(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	tx, err := db.BeginTx(context.Background(), nil)
+	be.NilErr(t, err)
+	_, err = tx.Exec("INSERT INTO clients (code, secret, redirect_uri) VALUES (?, '', '');", "lock")
+	be.NilErr(t, err)
+	return func() { _ = tx.Rollback() }
+}
+
+func busyRepo(t *testing.T, policy RetryPolicy) *repo {
+	conf := Config{
+		Path:       t.TempDir(),
+		LogFn:      t.Logf,
+		ErrFn:      t.Logf,
+		Connection: ConnectionOptions{BusyTimeout: time.Millisecond},
+		Retry:      policy,
+	}
+	be.NilErr(t, Bootstrap(conf))
+	r, err := New(conf)
+	be.NilErr(t, err)
+	be.NilErr(t, r.Open())
+	t.Cleanup(r.Close)
+	return r
+}
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		wantMax time.Duration
+	}{
+		{
+			name:    "default first attempt",
+			attempt: 1,
+			wantMax: defaultRetryInitialBackoff,
+		},
+		{
+			name:    "default third attempt",
+			attempt: 3,
+			wantMax: 4 * defaultRetryInitialBackoff,
+		},
+		{
+			name:    "default is capped",
+			attempt: 100,
+			wantMax: defaultRetryMaxBackoff,
+		},
+		{
+			name:    "custom",
+			policy:  RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 3 * time.Second},
+			attempt: 2,
+			wantMax: 2 * time.Second,
+		},
+		{
+			name:    "custom is capped",
+			policy:  RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 3 * time.Second},
+			attempt: 3,
+			wantMax: 3 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 100 {
+				got := tt.policy.backoff(tt.attempt)
+				if got < tt.wantMax/2 || got > tt.wantMax {
+					t.Fatalf("backoff(%d) = %s, expected between %s and %s", tt.attempt, got, tt.wantMax/2, tt.wantMax)
+				}
+			}
+		})
+	}
+}
+
+func Test_repo_retry(t *testing.T) {
+	r := busyRepo(t, RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+
+	release := lockDatabase(t, r)
+	_, busyErr := r.conn.Exec("INSERT INTO clients (code, secret, redirect_uri) VALUES (?, '', '');", "test")
+	release()
+	be.True(t, IsRetryable(busyErr))
+
+	tests := []struct {
+		name         string
+		ctx          context.Context
+		errs         []error
+		wantAttempts int
+		wantErr      error
+	}{
+		{
+			name:         "success",
+			ctx:          context.Background(),
+			wantAttempts: 1,
+		},
+		{
+			name:         "not retryable",
+			ctx:          context.Background(),
+			errs:         []error{errors.Newf("test")},
+			wantAttempts: 1,
+			wantErr:      errors.Newf("test"),
+		},
+		{
+			name:         "success after busy",
+			ctx:          context.Background(),
+			errs:         []error{busyErr, busyErr},
+			wantAttempts: 3,
+		},
+		{
+			name:         "out of attempts",
+			ctx:          context.Background(),
+			errs:         []error{busyErr, busyErr, busyErr, busyErr},
+			wantAttempts: 3,
+			wantErr:      busyErr,
+		},
+		{
+			name: "canceled context",
+			ctx: func() context.Context {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx
+			}(),
+			errs:         []error{busyErr, busyErr},
+			wantAttempts: 1,
+			wantErr:      context.Canceled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := r.retry(tt.ctx, func() error {
+				attempts++
+				if attempts <= len(tt.errs) {
+					return tt.errs[attempts-1]
+				}
+				return nil
+			})
+			be.Equal(t, tt.wantAttempts, attempts)
+			if tt.wantErr == nil {
+				be.NilErr(t, err)
+			} else if !errors.Is(err, tt.wantErr) && err.Error() != tt.wantErr.Error() {
+				t.Errorf("retry() error = %v, expected %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_repo_SaveContext_retriesWhileBusy(t *testing.T) {
+	ob := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType}
+	r := busyRepo(t, RetryPolicy{MaxAttempts: 100, InitialBackoff: 5 * time.Millisecond, MaxBackoff: 20 * time.Millisecond})
+
+	release := lockDatabase(t, r)
+	time.AfterFunc(50*time.Millisecond, release)
+
+	_, err := r.SaveContext(context.Background(), ob)
+	be.NilErr(t, err)
+
+	it, err := r.Load(ob.ID)
+	be.NilErr(t, err)
+	be.Equal(t, ob.ID, it.GetLink())
+}
+
+func Test_repo_SaveContext_busyError(t *testing.T) {
+	ob := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType}
+	r := busyRepo(t, RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond})
+
+	release := lockDatabase(t, r)
+	t.Cleanup(release)
+
+	_, err := r.SaveContext(context.Background(), ob)
+	if !errors.IsServiceUnavailable(err) {
+		t.Errorf("SaveContext() expected service unavailable error, received %v", err)
+	}
+	_, err = r.Load(ob.ID)
+	if !errors.IsNotFound(err) {
+		t.Errorf("Load() expected not found error after failed save, received %v", err)
+	}
+}
+
+func Test_repo_writeTx_beginError(t *testing.T) {
+	ob := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType}
+	r := busyRepo(t, RetryPolicy{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := r.SaveContext(ctx, ob)
+	be.True(t, errors.Is(err, context.Canceled))
+	be.True(t, errors.Is(r.AddToContext(ctx, "https://example.com/outbox", ob), context.Canceled))
+	be.True(t, errors.Is(r.RemoveFromContext(ctx, "https://example.com/outbox", ob), context.Canceled))
+	be.True(t, errors.Is(r.DeleteContext(ctx, ob), context.Canceled))
+}
+
+func Test_repo_writeTx_keepsCacheOnRollback(t *testing.T) {
+	colIRI := vocab.IRI("https://example.com/inbox")
+	ob := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType}
+
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap, withOrderedCollection(colIRI),
+		withGeneratedItems(vocab.ItemCollection{ob}))
+	t.Cleanup(r.Close)
+	be.NilErr(t, r.AddTo(colIRI, ob.GetLink()))
+	r.cache = NewLRUCache(10, 0)
+	r.cache.Store(ob.ID, ob)
+	r.cache.Store(colIRI, &vocab.OrderedCollection{ID: colIRI})
+
+	errStop := errors.Newf("stop")
+	err := r.writeTx(context.Background(), func(tx *sql.Tx) error {
+		if _, err := r.delete(context.Background(), tx, ob); err != nil {
+			return err
+		}
+		return errStop
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("writeTx() error = %v, expected %v", err, errStop)
+	}
+	if r.cache.Load(ob.ID) == nil {
+		t.Errorf("the item deleted in the rolled back transaction has been removed from the cache")
+	}
+	if r.cache.Load(colIRI) == nil {
+		t.Errorf("the collection changed in the rolled back transaction has been removed from the cache")
+	}
+
+	be.NilErr(t, r.Delete(ob))
+	if r.cache.Load(ob.ID) != nil {
+		t.Errorf("the deleted item is still in the cache")
+	}
+	if r.cache.Load(colIRI) != nil {
+		t.Errorf("the collection the item was removed from is still in the cache")
+	}
+}
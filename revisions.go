@@ -0,0 +1,146 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+)
+
+// Revision is a previous version of an object or actor.
+type Revision struct {
+	// ID identifies the revision, it increases with every new revision.
+	ID int64
+	// IRI is the IRI of the object or actor.
+	IRI vocab.IRI
+	// Activity is the IRI of the activity that replaced this version, if it was known when saving.
+	Activity vocab.IRI
+	// Published is the time when this version was replaced.
+	Published time.Time
+}
+
+type revisionActivityKey struct{}
+
+// WithRevisionActivity returns a context that makes the SaveContext calls record activity as the cause of
+// the revisions they create.
+func WithRevisionActivity(ctx context.Context, activity vocab.IRI) context.Context {
+	return context.WithValue(ctx, revisionActivityKey{}, activity)
+}
+
+func revisionActivity(ctx context.Context) any {
+	if act, ok := ctx.Value(revisionActivityKey{}).(vocab.IRI); ok && act != "" {
+		return act
+	}
+	return nil
+}
+
+// keepsRevisions returns true if the previous versions of the items stored in table are saved as revisions.
+func (r *repo) keepsRevisions(table string) bool {
+	return r.revisions && (table == string(filters.ObjectsType) || table == string(filters.ActorsType))
+}
+
+const saveRevisionQ = "INSERT INTO revisions (iri, raw, activity) SELECT iri, raw, ? FROM %s WHERE iri = ? AND raw IS NOT ?;"
+
+// saveRevision stores the current version of the item at iri from table, if it is different from raw,
+// which is going to replace it.
+func (r *repo) saveRevision(ctx context.Context, tx *sql.Tx, table string, iri vocab.IRI, raw []byte) error {
+	if !r.keepsRevisions(table) {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(saveRevisionQ, table), revisionActivity(ctx), iri, string(raw)); err != nil {
+		return wrapSQLError(err, "unable to save revision of %s", iri)
+	}
+	return nil
+}
+
+// Revisions returns the previous versions of the object or actor at iri, newest first.
+func (r *repo) Revisions(ctx context.Context, iri vocab.IRI) ([]Revision, error) {
+	if r == nil || r.ro == nil {
+		return nil, errNotOpen
+	}
+
+	sel := "SELECT id, iri, activity, published FROM revisions WHERE iri = ? ORDER BY id DESC;"
+	rows, err := r.reader().QueryContext(ctx, sel, iri)
+	if err != nil {
+		return nil, wrapSQLError(err, "unable to load revisions")
+	}
+	defer rows.Close()
+
+	revisions := make([]Revision, 0)
+	for rows.Next() {
+		var rev Revision
+		var activity sql.NullString
+		var published string
+		if err = rows.Scan(&rev.ID, &rev.IRI, &activity, &published); err != nil {
+			return nil, wrapSQLError(err, "scan values error")
+		}
+		rev.Activity = vocab.IRI(activity.String)
+		rev.Published, _ = time.Parse(timestampLayout, published)
+		revisions = append(revisions, rev)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, wrapSQLError(err, "unable to load revisions")
+	}
+	return revisions, nil
+}
+
+// LoadRevision returns the version of the object or actor at iri, stored in the revision with id.
+func (r *repo) LoadRevision(ctx context.Context, iri vocab.IRI, id int64) (vocab.Item, error) {
+	if r == nil || r.ro == nil {
+		return nil, errNotOpen
+	}
+
+	var raw []byte
+	sel := "SELECT raw FROM revisions WHERE iri = ? AND id = ?;"
+	if err := r.reader().QueryRowContext(ctx, sel, iri, id).Scan(&raw); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.NotFoundf("revision %d of %s not found", id, iri)
+		}
+		return nil, wrapSQLError(err, "unable to load revision")
+	}
+	it, err := decodeItemFn(raw)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to unmarshal revision")
+	}
+	return it, nil
+}
+
+// pruneOverKeptRevisions matches the revisions of an item that are older than its last kept ones.
+const pruneOverKeptRevisions = `id IN (SELECT id FROM
+	(SELECT id, row_number() OVER (PARTITION BY iri ORDER BY id DESC) AS n FROM revisions) WHERE n > ?)`
+
+// PruneRevisions removes the revisions published before the before time, and the ones older than the last keep
+// revisions of each object or actor. A zero before, or a keep that's not positive, disables that limit.
+// It returns the number of revisions removed.
+// See RetentionPolicy.RevisionsMaxAge and RetentionPolicy.KeepRevisions for pruning them periodically.
+func (r *repo) PruneRevisions(ctx context.Context, before time.Time, keep int) (int64, error) {
+	if r == nil || r.conn == nil {
+		return 0, errNotOpen
+	}
+
+	conds := make([]string, 0, 2)
+	args := make([]any, 0, 2)
+	if !before.IsZero() {
+		conds = append(conds, "published < ?")
+		args = append(args, before.UTC().Format(timestampLayout))
+	}
+	if keep > 0 {
+		conds = append(conds, pruneOverKeptRevisions)
+		args = append(args, keep)
+	}
+	if len(conds) == 0 {
+		return 0, nil
+	}
+
+	query := "DELETE FROM revisions WHERE " + strings.Join(conds, " OR ") + ";"
+	res, err := r.exec(ctx, query, args...)
+	if err != nil {
+		return 0, wrapSQLError(err, "unable to prune revisions")
+	}
+	return res.RowsAffected()
+}
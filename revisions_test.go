@@ -0,0 +1,233 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/carlmjohnson/be"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+func withRevisions(_ *testing.T, r *repo) *repo {
+	r.revisions = true
+	return r
+}
+
+func Test_repo_Revisions(t *testing.T) {
+	v1 := &vocab.Object{ID: "https://example.com/1", Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("v1")}
+	v2 := &vocab.Object{ID: v1.ID, Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("v2")}
+	v3 := &vocab.Object{ID: v1.ID, Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("v3")}
+	update := vocab.IRI("https://example.com/activities/update")
+	act := &vocab.Activity{ID: "https://example.com/activities/1", Type: vocab.CreateType, Object: v1.ID}
+
+	tests := []struct {
+		name     string
+		setupFns []initFn
+		saves    vocab.ItemCollection
+		iri      vocab.IRI
+		want     []Revision
+		wantErr  error
+	}{
+		{
+			name:    "not open",
+			iri:     v1.ID,
+			wantErr: errNotOpen,
+		},
+		{
+			name:     "disabled",
+			setupFns: []initFn{withOpenRoot, withBootstrap},
+			saves:    vocab.ItemCollection{v1, v2},
+			iri:      v1.ID,
+			want:     []Revision{},
+		},
+		{
+			name:     "unchanged item",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withRevisions},
+			saves:    vocab.ItemCollection{v1, v1},
+			iri:      v1.ID,
+			want:     []Revision{},
+		},
+		{
+			name:     "updates",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withRevisions},
+			saves:    vocab.ItemCollection{v1, v2, v3},
+			iri:      v1.ID,
+			want: []Revision{
+				{IRI: v1.ID, Activity: update},
+				{IRI: v1.ID, Activity: update},
+			},
+		},
+		{
+			name:     "activities don't have revisions",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withRevisions},
+			saves:    vocab.ItemCollection{act, &vocab.Activity{ID: act.ID, Type: vocab.CreateType, Object: v2.ID}},
+			iri:      act.ID,
+			want:     []Revision{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mockRepo(t, fields{path: t.TempDir()}, tt.setupFns...)
+			t.Cleanup(r.Close)
+
+			ctx := WithRevisionActivity(context.Background(), update)
+			for _, it := range tt.saves {
+				_, err := r.SaveContext(ctx, it)
+				be.NilErr(t, err)
+			}
+
+			got, err := r.Revisions(context.Background(), tt.iri)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Revisions() error = %v, expected %v", err, tt.wantErr)
+			}
+			be.Equal(t, len(tt.want), len(got))
+			for i, rev := range got {
+				be.Equal(t, tt.want[i].IRI, rev.IRI)
+				be.Equal(t, tt.want[i].Activity, rev.Activity)
+				be.True(t, !rev.Published.IsZero())
+				if i > 0 {
+					be.True(t, rev.ID < got[i-1].ID)
+				}
+			}
+		})
+	}
+}
+
+func Test_repo_LoadRevision(t *testing.T) {
+	v1 := &vocab.Object{ID: "https://example.com/1", Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("v1")}
+	v2 := &vocab.Object{ID: v1.ID, Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("v2")}
+	v3 := &vocab.Object{ID: v1.ID, Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("v3")}
+
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap, withRevisions)
+	t.Cleanup(r.Close)
+
+	ctx := context.Background()
+	for _, it := range (vocab.ItemCollection{v1, v2, v3}) {
+		_, err := r.SaveContext(ctx, it)
+		be.NilErr(t, err)
+	}
+
+	revisions, err := r.Revisions(ctx, v1.ID)
+	be.NilErr(t, err)
+	be.Equal(t, 2, len(revisions))
+	be.Equal(t, vocab.IRI(""), revisions[0].Activity)
+
+	for i, want := range []*vocab.Object{v2, v1} {
+		it, err := r.LoadRevision(ctx, v1.ID, revisions[i].ID)
+		be.NilErr(t, err)
+		be.NilErr(t, vocab.OnObject(it, func(ob *vocab.Object) error {
+			if !cmp.Equal(want.Content, ob.Content) {
+				t.Errorf("LoadRevision() content differs %s", cmp.Diff(want.Content, ob.Content))
+			}
+			return nil
+		}))
+	}
+
+	current, err := r.Load(v1.ID)
+	be.NilErr(t, err)
+	be.NilErr(t, vocab.OnObject(current, func(ob *vocab.Object) error {
+		if !cmp.Equal(v3.Content, ob.Content) {
+			t.Errorf("Load() content differs %s", cmp.Diff(v3.Content, ob.Content))
+		}
+		return nil
+	}))
+
+	_, err = r.LoadRevision(ctx, v1.ID, -1)
+	if !errors.IsNotFound(err) {
+		t.Errorf("LoadRevision() expected not found error, received %v", err)
+	}
+}
+
+func Test_repo_PruneRevisions(t *testing.T) {
+	ob := &vocab.Object{ID: "https://example.com/1", Type: vocab.NoteType}
+	other := &vocab.Object{ID: "https://example.com/2", Type: vocab.NoteType}
+
+	// NOTE(marius): the first object has three revisions, and the second one has one
+	withUpdates := func(t *testing.T, r *repo) *repo {
+		for _, content := range []string{"v1", "v2", "v3", "v4"} {
+			_, err := r.Save(&vocab.Object{ID: ob.ID, Type: ob.Type, Content: vocab.DefaultNaturalLanguage(content)})
+			be.NilErr(t, err)
+		}
+		for _, content := range []string{"v1", "v2"} {
+			_, err := r.Save(&vocab.Object{ID: other.ID, Type: other.Type, Content: vocab.DefaultNaturalLanguage(content)})
+			be.NilErr(t, err)
+		}
+		return r
+	}
+
+	tests := []struct {
+		name     string
+		setupFns []initFn
+		before   time.Time
+		keep     int
+		want     int64
+		wantLeft map[vocab.IRI]int
+		wantErr  error
+	}{
+		{
+			name:    "not open",
+			wantErr: errNotOpen,
+		},
+		{
+			name:     "no limits",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withRevisions, withUpdates},
+			wantLeft: map[vocab.IRI]int{ob.ID: 3, other.ID: 1},
+		},
+		{
+			name:     "keep the last one",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withRevisions, withUpdates},
+			keep:     1,
+			want:     2,
+			wantLeft: map[vocab.IRI]int{ob.ID: 1, other.ID: 1},
+		},
+		{
+			name:     "older than the future",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withRevisions, withUpdates},
+			before:   time.Now().Add(time.Hour),
+			want:     4,
+			wantLeft: map[vocab.IRI]int{ob.ID: 0, other.ID: 0},
+		},
+		{
+			name:     "older than the past",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withRevisions, withUpdates},
+			before:   time.Now().Add(-time.Hour),
+			keep:     2,
+			want:     1,
+			wantLeft: map[vocab.IRI]int{ob.ID: 2, other.ID: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mockRepo(t, fields{path: t.TempDir()}, tt.setupFns...)
+			t.Cleanup(r.Close)
+
+			got, err := r.PruneRevisions(context.Background(), tt.before, tt.keep)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("PruneRevisions() error = %v, expected %v", err, tt.wantErr)
+			}
+			be.Equal(t, tt.want, got)
+
+			for iri, want := range tt.wantLeft {
+				revisions, err := r.Revisions(context.Background(), iri)
+				be.NilErr(t, err)
+				be.Equal(t, want, len(revisions))
+			}
+			// NOTE(marius): the most recent revisions are the ones that are kept
+			if tt.keep > 0 {
+				revisions, err := r.Revisions(context.Background(), ob.ID)
+				be.NilErr(t, err)
+				it, err := r.LoadRevision(context.Background(), ob.ID, revisions[0].ID)
+				be.NilErr(t, err)
+				be.NilErr(t, vocab.OnObject(it, func(o *vocab.Object) error {
+					if want := vocab.DefaultNaturalLanguage("v3"); !cmp.Equal(want, o.Content) {
+						t.Errorf("LoadRevision() content differs %s", cmp.Diff(want, o.Content))
+					}
+					return nil
+				}))
+			}
+		})
+	}
+}
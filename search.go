@@ -0,0 +1,248 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+)
+
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "br": true, "dd": true, "div": true,
+	"dl": true, "dt": true, "footer": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hr": true, "li": true, "ol": true, "p": true, "pre": true, "section": true, "table": true,
+	"td": true, "th": true, "tr": true, "ul": true,
+}
+
+// stripHTML removes the markup from s, and returns only its text, with the HTML entities decoded
+// and the whitespace collapsed.
+// The contents of the script and style elements are discarded.
+func stripHTML(s string) string {
+	if !strings.ContainsAny(s, "<&") {
+		return s
+	}
+
+	b := strings.Builder{}
+	b.Grow(len(s))
+	for len(s) > 0 {
+		start := strings.IndexByte(s, '<')
+		if start < 0 {
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:start])
+		end := strings.IndexByte(s[start:], '>')
+		if end < 0 {
+			// NOTE(marius): an unterminated tag, we treat it as text
+			b.WriteString(s[start:])
+			break
+		}
+		tag := strings.ToLower(s[start+1 : start+end])
+		s = s[start+end+1:]
+		for _, skip := range []string{"script", "style"} {
+			if tag == skip || strings.HasPrefix(tag, skip+" ") {
+				if i := strings.Index(strings.ToLower(s), "</"+skip); i >= 0 {
+					s = s[i:]
+				} else {
+					s = ""
+				}
+			}
+		}
+		// NOTE(marius): block elements separate words, eg: "<p>one</p><p>two</p>", while inline ones
+		// can be in the middle of one, eg: "<em>fox</em>es"
+		if name, _, _ := strings.Cut(strings.Trim(tag, "/ "), " "); blockElements[name] {
+			b.WriteByte(' ')
+		}
+	}
+	return strings.Join(strings.FieldsFunc(html.UnescapeString(b.String()), unicode.IsSpace), " ")
+}
+
+// stripNullHTML returns the text of v without the markup, or nil when v is NULL.
+func stripNullHTML(v sql.NullString) any {
+	if !v.Valid {
+		return nil
+	}
+	return stripHTML(v.String)
+}
+
+// ftsQuery converts the text received from the user to an FTS5 query that matches the documents
+// containing all the words, with the last one being treated as a prefix.
+// Every word is quoted so the FTS5 query syntax can not be used.
+func ftsQuery(q string) string {
+	terms := strings.Fields(q)
+	for i, t := range terms {
+		terms[i] = `"` + strings.ReplaceAll(t, `"`, `""`) + `"`
+	}
+	if len(terms) > 0 {
+		terms[len(terms)-1] += "*"
+	}
+	return strings.Join(terms, " ")
+}
+
+// The weights of the name, preferred_username, summary and content columns when ranking search results.
+const searchRank = "bm25(search, 10.0, 10.0, 2.0, 1.0)"
+
+const searchQuery = `SELECT coalesce(o.raw, a.raw) FROM search s
+  INNER JOIN search_docs d ON d.id = s.rowid
+  LEFT JOIN objects o ON o.iri = d.iri
+  LEFT JOIN actors a ON a.iri = d.iri
+  WHERE search MATCH ? AND coalesce(o.raw, a.raw) IS NOT NULL
+  ORDER BY ` + searchRank + ` LIMIT ?;`
+
+const searchIndexQ = `SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'search')
+  AND EXISTS (SELECT 1 FROM pragma_module_list WHERE name = 'fts5');`
+
+// hasSearchIndex checks if the database has the full text search index, and if the SQLite library
+// of the driver can use it.
+func hasSearchIndex(ctx context.Context, conn querier) (bool, error) {
+	var ok bool
+	if err := conn.QueryRowContext(ctx, searchIndexQ).Scan(&ok); err != nil {
+		return false, wrapSQLError(err, "unable to check the search index")
+	}
+	return ok, nil
+}
+
+// searchValuesQ select the values of an item that get indexed, from the tables that are part of the search index:
+// its name, preferred username, summary and content.
+// NOTE(marius): the Tombstones are not indexed.
+var searchValuesQ = map[string]string{
+	"objects": "SELECT name, NULL, summary, content FROM objects WHERE iri = ? AND coalesce(type, '') != 'Tombstone';",
+	"actors":  "SELECT name, preferred_username, json_extract(raw, '$.summary'), NULL FROM actors WHERE iri = ? AND coalesce(type, '') != 'Tombstone';",
+}
+
+const (
+	deleteSearchQ    = "DELETE FROM search WHERE rowid = (SELECT id FROM search_docs WHERE iri = ?);"
+	deleteSearchDocQ = "DELETE FROM search_docs WHERE iri = ?;"
+	insertSearchDocQ = "INSERT OR IGNORE INTO search_docs (iri) VALUES (?);"
+	insertSearchQ    = "INSERT INTO search (rowid, name, preferred_username, summary, content) SELECT id, ?, ?, ?, ? FROM search_docs WHERE iri = ?;"
+	searchableItemsQ = "SELECT iri FROM %s WHERE coalesce(type, '') != 'Tombstone' ORDER BY iri;"
+)
+
+// indexSearchDoc replaces the indexed values of iri with the ones from its row in table.
+// The HTML markup is removed from the values before they get written to the index.
+func indexSearchDoc(ctx context.Context, tx querier, table string, iri vocab.IRI) error {
+	sel, ok := searchValuesQ[table]
+	if !ok {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, deleteSearchQ, iri); err != nil {
+		return wrapSQLError(err, "unable to remove %s from the search index", iri)
+	}
+
+	var name, username, summary, content sql.NullString
+	err := tx.QueryRowContext(ctx, sel, iri).Scan(&name, &username, &summary, &content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return wrapSQLError(err, "unable to load the searchable values of %s", iri)
+	}
+	if _, err = tx.ExecContext(ctx, insertSearchDocQ, iri); err != nil {
+		return wrapSQLError(err, "unable to add %s to the search index", iri)
+	}
+	values := []any{stripNullHTML(name), username, stripNullHTML(summary), stripNullHTML(content), iri}
+	if _, err = tx.ExecContext(ctx, insertSearchQ, values...); err != nil {
+		return wrapSQLError(err, "unable to add %s to the search index", iri)
+	}
+	return nil
+}
+
+// updateSearchIndex updates the search index, if the database has one, after iri was saved in table.
+func updateSearchIndex(ctx context.Context, tx querier, table string, iri vocab.IRI) error {
+	if _, ok := searchValuesQ[table]; !ok {
+		return nil
+	}
+	if ok, err := hasSearchIndex(ctx, tx); err != nil || !ok {
+		return err
+	}
+	return indexSearchDoc(ctx, tx, table, iri)
+}
+
+// removeFromSearchIndex removes iri from the search index, if the database has one.
+func removeFromSearchIndex(ctx context.Context, tx querier, iri vocab.IRI) error {
+	if ok, err := hasSearchIndex(ctx, tx); err != nil || !ok {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, deleteSearchQ, iri); err != nil {
+		return wrapSQLError(err, "unable to remove %s from the search index", iri)
+	}
+	if _, err := tx.ExecContext(ctx, deleteSearchDocQ, iri); err != nil {
+		return wrapSQLError(err, "unable to remove %s from the search index", iri)
+	}
+	return nil
+}
+
+// indexAllForSearch adds all the objects and actors to the search index.
+func indexAllForSearch(ctx context.Context, tx *sql.Tx) error {
+	for table := range searchValuesQ {
+		iris, err := selectIRIs(ctx, tx, fmt.Sprintf(searchableItemsQ, table))
+		if err != nil {
+			return err
+		}
+		for _, iri := range iris {
+			if err = indexSearchDoc(ctx, tx, table, iri); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Search
+func (r *repo) Search(q string, maxItems int) (vocab.ItemCollection, error) {
+	return r.SearchContext(context.Background(), q, maxItems)
+}
+
+// SearchContext returns the objects and actors that match the text in q, ordered by relevance.
+// The matches on the name and preferred username of the items rank higher than the ones on the
+// summary and the content.
+//
+// The search index requires the FTS5 module of SQLite, when it's not available it returns a NotImplemented error.
+func (r *repo) SearchContext(ctx context.Context, q string, maxItems int) (vocab.ItemCollection, error) {
+	if r == nil || r.ro == nil {
+		return nil, errNotOpen
+	}
+	match := ftsQuery(q)
+	if match == "" {
+		return nil, errors.BadRequestf("empty search query")
+	}
+	if maxItems <= 0 || maxItems > filters.MaxItems {
+		maxItems = filters.MaxItems
+	}
+	ok, err := hasSearchIndex(ctx, r.reader())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.NotImplementedf("the full text search index is not available, it requires SQLite with FTS5 support")
+	}
+
+	rows, err := r.reader().QueryContext(ctx, searchQuery, match, maxItems)
+	if err != nil {
+		return nil, wrapSQLError(err, "unable to run search query")
+	}
+	defer rows.Close()
+
+	result := make(vocab.ItemCollection, 0)
+	for rows.Next() {
+		var raw []byte
+		if err = rows.Scan(&raw); err != nil {
+			return nil, wrapSQLError(err, "scan values error")
+		}
+		it, err := decodeItemFn(raw)
+		if err != nil {
+			return nil, errors.Annotatef(err, "unable to unmarshal raw item")
+		}
+		result = append(result, it)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, wrapSQLError(err, "unable to load search results")
+	}
+	return result, nil
+}
@@ -0,0 +1,226 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/carlmjohnson/be"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_stripHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		arg  string
+		want string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "plain text",
+			arg:  "some text",
+			want: "some text",
+		},
+		{
+			name: "paragraphs",
+			arg:  "<p>one</p><p>two <a href=\"https://example.com\">three</a></p>",
+			want: "one two three",
+		},
+		{
+			name: "entities",
+			arg:  "<p>fish &amp; chips&nbsp;&lt;3</p>",
+			want: "fish & chips <3",
+		},
+		{
+			name: "script and style",
+			arg:  "<style>p { color: red; }</style>text<SCRIPT type=\"text/javascript\">alert(1)</SCRIPT>",
+			want: "text",
+		},
+		{
+			name: "inline elements",
+			arg:  "<p>I like <em>fox</em>es<br/>a lot</p>",
+			want: "I like foxes a lot",
+		},
+		{
+			name: "unterminated tag",
+			arg:  "a < b",
+			want: "a < b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			be.Equal(t, tt.want, stripHTML(tt.arg))
+		})
+	}
+}
+
+func Test_ftsQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		arg  string
+		want string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "one word",
+			arg:  "test",
+			want: `"test"*`,
+		},
+		{
+			name: "multiple words",
+			arg:  " hello   wor",
+			want: `"hello" "wor"*`,
+		},
+		{
+			name: "fts syntax is quoted",
+			arg:  `name:"x OR y`,
+			want: `"name:""x" "OR" "y"*`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			be.Equal(t, tt.want, ftsQuery(tt.arg))
+		})
+	}
+}
+
+func Test_repo_Search(t *testing.T) {
+	note := &vocab.Object{
+		ID:      "https://example.com/objects/1",
+		Type:    vocab.NoteType,
+		Content: vocab.DefaultNaturalLanguage("<p>The quick <strong>brown</strong> fox</p>"),
+	}
+	article := &vocab.Object{
+		ID:      "https://example.com/objects/2",
+		Type:    vocab.ArticleType,
+		Name:    vocab.DefaultNaturalLanguage("Foxes"),
+		Content: vocab.DefaultNaturalLanguage("<p>An article about animals</p>"),
+	}
+	actor := &vocab.Actor{
+		ID:                "https://example.com/actors/jdoe",
+		Type:              vocab.PersonType,
+		PreferredUsername: vocab.DefaultNaturalLanguage("jdoe"),
+		Summary:           vocab.DefaultNaturalLanguage("<p>I like <em>fox</em>es</p>"),
+	}
+
+	withUpdatedNote := func(t *testing.T, r *repo) *repo {
+		updated := *note
+		updated.Content = vocab.DefaultNaturalLanguage("<p>The quick grey wolf</p>")
+		_, err := r.Save(&updated)
+		be.NilErr(t, err)
+		return r
+	}
+	withBatchedItems := func(t *testing.T, r *repo) *repo {
+		errs, err := r.SaveMany(context.Background(), note, article, actor)
+		be.NilErr(t, err)
+		for _, err = range errs {
+			be.NilErr(t, err)
+		}
+		return r
+	}
+	withRebuiltIndex := func(t *testing.T, r *repo) *repo {
+		if ok, _ := hasSearchIndex(context.Background(), r.conn); !ok {
+			return r
+		}
+		_, err := r.conn.Exec("DELETE FROM search; DELETE FROM search_docs;")
+		be.NilErr(t, err)
+		be.NilErr(t, r.writeTx(context.Background(), func(tx *sql.Tx) error {
+			return indexAllForSearch(context.Background(), tx)
+		}))
+		return r
+	}
+
+	tests := []struct {
+		name     string
+		setupFns []initFn
+		query    string
+		want     vocab.IRIs
+		wantErr  error
+	}{
+		{
+			name:    "not open",
+			query:   "fox",
+			wantErr: errNotOpen,
+		},
+		{
+			name:     "empty query",
+			setupFns: []initFn{withOpenRoot, withBootstrap},
+			wantErr:  errors.BadRequestf("empty search query"),
+		},
+		{
+			name:     "no results",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{note, article, actor})},
+			query:    "wolf",
+			want:     vocab.IRIs{},
+		},
+		{
+			name:     "markup is not indexed",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{note, article, actor})},
+			query:    "strong",
+			want:     vocab.IRIs{},
+		},
+		{
+			name:     "by preferred username",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{note, article, actor})},
+			query:    "jdo",
+			want:     vocab.IRIs{actor.ID},
+		},
+		{
+			name:     "name ranks higher than content",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{note, article, actor})},
+			query:    "fox",
+			want:     vocab.IRIs{article.ID, actor.ID, note.ID},
+		},
+		{
+			name:     "deleted items are removed from the index",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{note, article, actor}), withDeletedItems(article)},
+			query:    "fox",
+			want:     vocab.IRIs{actor.ID, note.ID},
+		},
+		{
+			name:     "updated items are indexed again",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{note, article, actor}), withUpdatedNote},
+			query:    "fox",
+			want:     vocab.IRIs{article.ID, actor.ID},
+		},
+		{
+			name:     "items saved in a batch are indexed",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withBatchedItems},
+			query:    "fox",
+			want:     vocab.IRIs{article.ID, actor.ID, note.ID},
+		},
+		{
+			name:     "rebuilt index",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withGeneratedItems(vocab.ItemCollection{note, article, actor}), withRebuiltIndex},
+			query:    "fox",
+			want:     vocab.IRIs{article.ID, actor.ID, note.ID},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mockRepo(t, fields{path: t.TempDir()}, tt.setupFns...)
+			t.Cleanup(r.Close)
+			if r.conn != nil {
+				if ok, _ := hasSearchIndex(context.Background(), r.conn); !ok {
+					t.Skip("the SQLite library of the driver doesn't have the FTS5 module")
+				}
+			}
+
+			got, err := r.Search(tt.query, 10)
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Errorf("Search() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+				return
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			be.AllEqual(t, tt.want, got.IRIs())
+		})
+	}
+}
@@ -323,6 +323,17 @@ func withGeneratedItems(items vocab.ItemCollection) initFn {
 	}
 }
 
+func withDeletedItems(items ...vocab.Item) initFn {
+	return func(t *testing.T, r *repo) *repo {
+		for _, it := range items {
+			if err := r.Delete(it); err != nil {
+				t.Errorf("unable to delete %T[%s]: %s", it, it.GetLink(), err)
+			}
+		}
+		return r
+	}
+}
+
 func withActivitiesToCollections(activities vocab.ItemCollection) initFn {
 	return func(t *testing.T, r *repo) *repo {
 		collectionIRI := vocab.Outbox.IRI(root)
@@ -470,6 +481,8 @@ func wantsRootOutbox(ff ...filters.Check) vocab.Item {
 	}
 	if len(ff) > 0 {
 		col.First = vocab.IRI(string(rootOutboxIRI) + "?" + filters.ToValues(filters.WithMaxCount(filters.MaxItems)).Encode())
+	} else {
+		col.First = keysetPage{size: filters.MaxItems}.iri(rootOutboxIRI, "", nil)
 	}
 
 	return col
@@ -0,0 +1,112 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+)
+
+// Repository is the set of operations on ActivityPub items that can be grouped in a single transaction
+// by calling repo.Tx.
+type Repository interface {
+	Load(vocab.IRI, ...filters.Check) (vocab.Item, error)
+	Save(vocab.Item) (vocab.Item, error)
+	AddTo(vocab.IRI, ...vocab.Item) error
+	RemoveFrom(vocab.IRI, ...vocab.Item) error
+	Delete(vocab.Item) error
+}
+
+var _ Repository = new(repo)
+
+// txRepo runs all its operations on the same *sql.Tx
+type txRepo struct {
+	r   *repo
+	tx  *sql.Tx
+	ctx context.Context
+
+	// touched holds the IRIs of the items changed during the transaction, which are evicted from
+	// the cache after it gets committed or rolled back.
+	touched vocab.IRIs
+}
+
+// Tx runs fn inside a single write transaction.
+// All the operations executed on the Repository received by fn are committed together when
+// fn returns a nil error, and are rolled back otherwise.
+// Like for the other write operations, the transaction is retried while the database is busy, so fn
+// can be called more than once, and it must not have side effects outside of the Repository it receives.
+func (r *repo) Tx(ctx context.Context, fn func(tx Repository) error) error {
+	if r == nil || r.conn == nil {
+		return errNotOpen
+	}
+	if fn == nil {
+		return nil
+	}
+
+	// NOTE(marius): the touched IRIs are kept between the attempts, as fn can change the cache directly
+	t := txRepo{ctx: ctx}
+	err := r.writeTx(ctx, func(tx *sql.Tx) error {
+		rr := *r
+		rr.tx = tx
+		t.r, t.tx = &rr, tx
+		return fn(&t)
+	})
+	r.evict(t.touched...)
+	return err
+}
+
+// Load
+func (t *txRepo) Load(iri vocab.IRI, ff ...filters.Check) (vocab.Item, error) {
+	return t.r.LoadContext(t.ctx, iri, ff...)
+}
+
+// Save
+func (t *txRepo) Save(it vocab.Item) (vocab.Item, error) {
+	if vocab.IsNil(it) {
+		return nil, errNilItem
+	}
+	t.touched = append(t.touched, it.GetLink())
+	return t.r.save(t.ctx, t.tx, it)
+}
+
+// AddTo
+func (t *txRepo) AddTo(col vocab.IRI, items ...vocab.Item) error {
+	if col.GetLink() == "" {
+		return errors.NotFoundf("unable to operate on empty collection IRI")
+	}
+	t.touched = append(t.touched, col.GetLink())
+	return t.r.addTo(t.ctx, t.tx, col, items...)
+}
+
+// RemoveFrom
+func (t *txRepo) RemoveFrom(col vocab.IRI, items ...vocab.Item) error {
+	if col.GetLink() == "" {
+		return errors.NotFoundf("unable to operate on empty collection IRI")
+	}
+	t.touched = append(t.touched, col.GetLink())
+	return t.r.removeFrom(t.ctx, t.tx, col, items...)
+}
+
+// Delete
+func (t *txRepo) Delete(it vocab.Item) error {
+	if vocab.IsNil(it) {
+		return nil
+	}
+
+	if vocab.IsCollection(it) {
+		return vocab.OnCollectionIntf(it, func(c vocab.CollectionInterface) error {
+			for _, it := range c.Collection() {
+				if err := t.Delete(it); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	t.touched = append(t.touched, it.GetLink())
+	changed, err := t.r.delete(t.ctx, t.tx, it)
+	t.touched = append(t.touched, changed...)
+	return err
+}
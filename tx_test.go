@@ -0,0 +1,136 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_repo_Tx(t *testing.T) {
+	colIRI := vocab.IRI("https://example.com/inbox")
+	ob := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType}
+	act := &vocab.Activity{ID: "https://example.com/activities/1", Type: vocab.CreateType, Object: ob.GetLink()}
+
+	errStop := errors.Newf("stop")
+
+	tests := []struct {
+		name      string
+		setupFns  []initFn
+		fn        func(tx Repository) error
+		wantErr   error
+		wantSaved bool
+	}{
+		{
+			name:    "not open",
+			wantErr: errNotOpen,
+		},
+		{
+			name:     "all operations are committed",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withOrderedCollection(colIRI)},
+			fn: func(tx Repository) error {
+				if _, err := tx.Save(ob); err != nil {
+					return err
+				}
+				if _, err := tx.Save(act); err != nil {
+					return err
+				}
+				// NOTE(marius): the uncommitted object needs to be visible inside the transaction
+				return tx.AddTo(colIRI, ob.GetLink(), act.GetLink())
+			},
+			wantSaved: true,
+		},
+		{
+			name:     "all operations are rolled back on error",
+			setupFns: []initFn{withOpenRoot, withBootstrap, withOrderedCollection(colIRI)},
+			fn: func(tx Repository) error {
+				if _, err := tx.Save(ob); err != nil {
+					return err
+				}
+				if _, err := tx.Save(act); err != nil {
+					return err
+				}
+				if err := tx.AddTo(colIRI, ob.GetLink(), act.GetLink()); err != nil {
+					return err
+				}
+				return errStop
+			},
+			wantErr:   errStop,
+			wantSaved: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mockRepo(t, fields{path: t.TempDir()}, tt.setupFns...)
+			t.Cleanup(r.Close)
+
+			err := r.Tx(context.Background(), tt.fn)
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Errorf("Tx() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+				return
+			}
+			if r.conn == nil {
+				return
+			}
+
+			for _, it := range (vocab.ItemCollection{ob, act}) {
+				_, err = r.Load(it.GetLink())
+				if tt.wantSaved && err != nil {
+					t.Errorf("Load() after Tx() error = %v", err)
+				}
+				if !tt.wantSaved && !errors.IsNotFound(err) {
+					t.Errorf("Load() after rolled back Tx() expected not found error, received %v", err)
+				}
+			}
+
+			col, err := r.Load(colIRI)
+			if err != nil {
+				t.Errorf("Load() collection after Tx() error = %v", err)
+				return
+			}
+			_ = vocab.OnCollectionIntf(col, func(col vocab.CollectionInterface) error {
+				for _, it := range (vocab.ItemCollection{ob, act}) {
+					if tt.wantSaved != col.Contains(it.GetLink()) {
+						t.Errorf("collection after Tx() contains %s: %t, expected %t", it.GetLink(), !tt.wantSaved, tt.wantSaved)
+					}
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func Test_repo_Tx_evictsTouchedItems(t *testing.T) {
+	colIRI := vocab.IRI("https://example.com/inbox")
+	ob := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType}
+	other := &vocab.Object{ID: "https://example.com/objects/2", Type: vocab.NoteType}
+
+	r := mockRepo(t, fields{path: t.TempDir()}, withOpenRoot, withBootstrap, withOrderedCollection(colIRI),
+		withGeneratedItems(vocab.ItemCollection{ob, other}))
+	t.Cleanup(r.Close)
+	r.cache = NewLRUCache(10, 0)
+
+	errStop := errors.Newf("stop")
+	err := r.Tx(context.Background(), func(tx Repository) error {
+		r.cache.Store(colIRI, &vocab.OrderedCollection{ID: colIRI})
+		r.cache.Store(other.ID, other)
+		if err := tx.RemoveFrom(colIRI, ob.GetLink()); err != nil {
+			return err
+		}
+		if err := tx.Delete(other); err != nil {
+			return err
+		}
+		return errStop
+	})
+	if !cmp.Equal(err, errStop, EquateWeakErrors) {
+		t.Fatalf("Tx() error = %s", cmp.Diff(errStop, err, EquateWeakErrors))
+	}
+	if r.cache.Load(colIRI) != nil {
+		t.Errorf("the collection changed by the rolled back Tx() is still in the cache")
+	}
+	if r.cache.Load(other.ID) != nil {
+		t.Errorf("the item deleted by the rolled back Tx() is still in the cache")
+	}
+}